@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// batchJob is one file of a batch run.
+type batchJob struct {
+	in, out string
+	err     error
+}
+
+// runBatch migrates every file matching --in into --out-dir, keeping the
+// directory layout below the pattern's fixed prefix. Files run in parallel on
+// one shared engine, and a failing file does not stop the others.
+func runBatch(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	ef := addEngineFlags(fs)
+	from := fs.String("from", "", "source version (detected per file when omitted)")
+	to := fs.String("to", "", "target version, or 'latest'")
+	in := fs.String("in", "", "glob of input configs; '**' matches any number of directories")
+	outDir := fs.String("out-dir", "", "directory to write migrated configs to")
+	jobs := fs.Int("j", runtime.NumCPU(), "number of files to migrate in parallel")
+	format := fs.String("format", "", "output format: json, yaml or toml; defaults to each input's format")
+	pretty := fs.Bool("pretty", true, "pretty-print JSON")
+	via := fs.String("via", "", "comma-separated versions the migration route must pass through, in order")
+	allowLossy := fs.Bool("allow-lossy", false, "run generated reverses that cannot undo every forward step")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *to == "" || *in == "" || *outDir == "" || *jobs < 1 || *format == formatPatch || !validFormat(*format) {
+		fmt.Fprintln(os.Stderr, "Usage: migrator batch --migrations ./migrations --in 'configs/**/*.json' --out-dir out/ [--from v1] --to v2 [-j 8] [--format json|yaml|toml]")
+		return exitUsage
+	}
+
+	eng, err := ef.load()
+	if err != nil {
+		return report(err)
+	}
+	root, files, err := expandGlob(*in)
+	if err != nil {
+		return report(err)
+	}
+	if len(files) == 0 {
+		return report(fmt.Errorf("no files match %s", *in))
+	}
+
+	batch := make([]batchJob, len(files))
+	for i, f := range files {
+		rel, err := filepath.Rel(root, f)
+		if err != nil {
+			return report(err)
+		}
+		batch[i] = batchJob{in: f, out: filepath.Join(*outDir, rel)}
+	}
+
+	opts := migrateOptions(*via, *allowLossy)
+	next := make(chan *batchJob)
+	var wg sync.WaitGroup
+	for w := 0; w < *jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range next {
+				job.err = migrateFile(eng, job, *from, *to, *format, *pretty, opts)
+			}
+		}()
+	}
+	for i := range batch {
+		next <- &batch[i]
+	}
+	close(next)
+	wg.Wait()
+
+	if printBatchSummary(os.Stdout, *to, batch) {
+		return 0
+	}
+	return exitBatch
+}
+
+// migrateFile migrates one file of a batch and writes the result to job.out.
+func migrateFile(eng *migrate.Engine, job *batchJob, from, to, format string, pretty bool, opts []migrate.Option) error {
+	codec := inputCodec(job.in, "")
+	cfg, err := readConfig(job.in, codec)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		if from, err = eng.DetectVersion(cfg); err != nil {
+			return err
+		}
+	}
+	res, err := eng.Migrate(cfg, from, to, opts...)
+	if err != nil {
+		return err
+	}
+	f := outputFormat("-", format, codec)
+	if f != codec.Name() {
+		// converting: give the output file the new format's extension
+		c, _ := migrate.CodecByName(f)
+		job.out = strings.TrimSuffix(job.out, filepath.Ext(job.out)) + c.Extensions()[0]
+	}
+	enc, err := encodeDoc(res.Doc, f, pretty)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(job.out), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(job.out, enc, 0o644)
+}
+
+// printBatchSummary writes the counts and every failed file's error, and
+// reports whether all files succeeded.
+func printBatchSummary(w io.Writer, to string, batch []batchJob) bool {
+	var failed, invalid []batchJob
+	for _, job := range batch {
+		var ve *migrate.ValidationError
+		switch {
+		case job.err == nil:
+		case errors.As(job.err, &ve):
+			invalid = append(invalid, job)
+		default:
+			failed = append(failed, job)
+		}
+	}
+	ok := len(batch) - len(failed) - len(invalid)
+	fmt.Fprintf(w, "batch to %s: %d file(s), %d succeeded, %d failed, %d failed validation\n", to, len(batch), ok, len(failed), len(invalid))
+	for _, group := range []struct {
+		title string
+		jobs  []batchJob
+	}{{"failed", failed}, {"failed validation", invalid}} {
+		if len(group.jobs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", group.title)
+		for _, job := range group.jobs {
+			msg := strings.TrimPrefix(describeError(job.err), "error: ")
+			msg = strings.TrimPrefix(msg, job.in+": ")
+			fmt.Fprintf(w, "  %s: %s\n", job.in, strings.ReplaceAll(msg, "\n", "\n  "))
+		}
+	}
+	return len(failed) == 0 && len(invalid) == 0
+}
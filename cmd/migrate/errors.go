@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// Exit codes, so scripts can tell failure classes apart.
+const (
+	exitUsage      = 1
+	exitLoad       = 2
+	exitNoPath     = 3
+	exitStep       = 4
+	exitValidation = 5
+	exitIO         = 6
+	exitVersion    = 7
+	exitLossy      = 8
+	exitMismatch   = 9
+	exitBatch      = 10 // some files of a batch or lines of an NDJSON stream failed
+	exitTest       = 11 // some migration test cases failed
+)
+
+// parseFlags parses args into fs, whose error handling must be
+// ContinueOnError. It returns false when the command should stop, with exit
+// code 0 after -help and exitUsage after a bad flag; the flag package has
+// already printed the problem and the flags to stderr.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	switch {
+	case err == nil:
+		return 0, true
+	case errors.Is(err, flag.ErrHelp):
+		return 0, false
+	}
+	return exitUsage, false
+}
+
+// exitCode maps an engine error to the process exit code.
+func exitCode(err error) int {
+	var (
+		le *migrate.LoadError
+		np *migrate.NoPathError
+		ar *migrate.AmbiguousRouteError
+		se *migrate.StepError
+		ve *migrate.ValidationError
+		uv *migrate.UnknownVersionError
+		av *migrate.AmbiguousVersionError
+		vm *migrate.VersionMismatchError
+		lo *migrate.LossyError
+	)
+	switch {
+	case errors.As(err, &le):
+		return exitLoad
+	case errors.As(err, &np), errors.As(err, &ar):
+		return exitNoPath
+	case errors.As(err, &se):
+		return exitStep
+	case errors.As(err, &ve):
+		return exitValidation
+	case errors.As(err, &uv), errors.As(err, &av), errors.As(err, &vm):
+		return exitVersion
+	case errors.As(err, &lo):
+		return exitLossy
+	}
+	return exitIO
+}
+
+// describeError prints err with whatever context the engine attached to it.
+func describeError(err error) string {
+	msg := "error: " + err.Error()
+	var se *migrate.StepError
+	if errors.As(err, &se) {
+		name := se.Migration
+		if name == "" {
+			name = se.From + "->" + se.To
+		}
+		if se.Source != "" {
+			name += " (" + se.Source + ")"
+		}
+		msg += fmt.Sprintf("\n  migration: %s\n  step:      %d (%s)", name, se.Step, se.Op)
+		if se.Path != "" {
+			msg += "\n  path:      " + se.Path
+		}
+	}
+	var lo *migrate.LossyError
+	if errors.As(err, &lo) {
+		for _, d := range lo.Dropped {
+			msg += fmt.Sprintf("\n  dropped: step %d (%s): %s", d.Index, d.Step, d.Reason)
+		}
+		for _, l := range lo.Lost {
+			msg += fmt.Sprintf("\n  would keep %s = %s", l.Path, compact(l.Value))
+		}
+		msg += "\n  rerun with --allow-lossy to migrate anyway"
+	}
+	return msg
+}
+
+// fail reports err on stderr and exits with its exit code.
+func fail(err error) {
+	os.Exit(report(err))
+}
+
+// report prints err like fail but returns the exit code instead of exiting.
+func report(err error) int {
+	fmt.Fprintln(os.Stderr, describeError(err))
+	return exitCode(err)
+}
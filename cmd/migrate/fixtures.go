@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// testCase is one fixture from a *.test.json file. A file holds one case or
+// an array of them. Input and expected documents are given inline or as files
+// relative to the test file; expectError instead expects the migration to fail
+// with an error containing it ("" accepts any error).
+type testCase struct {
+	Name         string          `json:"name,omitempty"`
+	From         string          `json:"from,omitempty"`
+	To           string          `json:"to"`
+	Via          []string        `json:"via,omitempty"`
+	AllowLossy   bool            `json:"allowLossy,omitempty"`
+	Input        *migrate.Object `json:"input,omitempty"`
+	InputFile    string          `json:"inputFile,omitempty"`
+	Expected     *migrate.Object `json:"expected,omitempty"`
+	ExpectedFile string          `json:"expectedFile,omitempty"`
+	ExpectError  *string         `json:"expectError,omitempty"`
+}
+
+// caseResult is the outcome of running one test case. A failure means the
+// migration did not do what the case expects; an error means the case itself
+// could not be run.
+type caseResult struct {
+	file, name string
+	failure    string
+	diffs      []migrate.Difference
+	err        error
+	elapsed    time.Duration
+}
+
+func (r caseResult) passed() bool { return r.failure == "" && r.err == nil }
+
+// runTest runs every test case found next to the migrations, or in the files
+// and directories given as arguments.
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	ef := addEngineFlags(fs)
+	junit := fs.String("junit", "", "also write the results as JUnit XML to this file")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	eng, err := ef.load()
+	if err != nil {
+		return report(err)
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{*ef.migrations}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		return report(err)
+	}
+
+	var results []caseResult
+	for _, file := range files {
+		results = append(results, runTestFile(eng, file)...)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.passed() {
+			fmt.Printf("ok    %s: %s\n", r.file, r.name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s: %s\n", r.file, r.name)
+		fmt.Print(indent(r.message(), "      "))
+	}
+	fmt.Printf("%d test case(s), %d passed, %d failed\n", len(results), len(results)-failed, failed)
+
+	if *junit != "" {
+		if err := writeJUnit(*junit, results); err != nil {
+			return report(err)
+		}
+	}
+	if failed > 0 {
+		return exitTest
+	}
+	return 0
+}
+
+// findTestFiles lists the *.test.json files among paths, looking through
+// directories and their subdirectories like the migration loader does.
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case d.IsDir() && path != p && strings.HasPrefix(d.Name(), "."):
+				return filepath.SkipDir
+			case !d.IsDir() && strings.HasSuffix(path, migrate.TestSuffix):
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runTestFile runs the cases in file. A file that cannot be parsed counts as
+// one errored case.
+func runTestFile(eng *migrate.Engine, file string) []caseResult {
+	cases, err := readTestFile(file)
+	if err != nil {
+		return []caseResult{{file: file, name: filepath.Base(file), err: err}}
+	}
+	results := make([]caseResult, len(cases))
+	for i, tc := range cases {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d (%s -> %s)", i, tc.From, tc.To)
+		}
+		start := time.Now()
+		results[i] = runCase(eng, filepath.Dir(file), tc)
+		results[i].file, results[i].name, results[i].elapsed = file, name, time.Since(start)
+	}
+	return results
+}
+
+func readTestFile(file string) ([]testCase, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var cases []testCase
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = dec.Decode(&cases)
+	} else {
+		var tc testCase
+		err = dec.Decode(&tc)
+		cases = append(cases, tc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, tc := range cases {
+		if tc.To == "" {
+			return nil, fmt.Errorf("case %d: missing to", i)
+		}
+		if (tc.Input == nil) == (tc.InputFile == "") {
+			return nil, fmt.Errorf("case %d: needs exactly one of input and inputFile", i)
+		}
+		if tc.ExpectError == nil && (tc.Expected == nil) == (tc.ExpectedFile == "") {
+			return nil, fmt.Errorf("case %d: needs exactly one of expected, expectedFile and expectError", i)
+		}
+	}
+	return cases, nil
+}
+
+// runCase migrates the case's input and checks the outcome.
+func runCase(eng *migrate.Engine, dir string, tc testCase) caseResult {
+	input, err := caseDoc(dir, tc.Input, tc.InputFile)
+	if err != nil {
+		return caseResult{err: err}
+	}
+	from := tc.From
+	if from == "" {
+		if from, err = eng.DetectVersion(input); err != nil {
+			return caseResult{err: err}
+		}
+	}
+	opts := migrateOptions(strings.Join(tc.Via, ","), tc.AllowLossy)
+	res, merr := eng.Migrate(input, from, tc.To, opts...)
+
+	if tc.ExpectError != nil {
+		switch {
+		case merr == nil:
+			return caseResult{failure: fmt.Sprintf("expected an error containing %q, but the migration succeeded", *tc.ExpectError)}
+		case !strings.Contains(merr.Error(), *tc.ExpectError):
+			return caseResult{failure: fmt.Sprintf("expected an error containing %q, got: %v", *tc.ExpectError, merr)}
+		}
+		return caseResult{}
+	}
+	if merr != nil {
+		return caseResult{failure: "migration failed: " + merr.Error()}
+	}
+	expected, err := caseDoc(dir, tc.Expected, tc.ExpectedFile)
+	if err != nil {
+		return caseResult{err: err}
+	}
+	if diffs := migrate.Diff(expected, res.Doc); len(diffs) > 0 {
+		return caseResult{failure: fmt.Sprintf("result differs from expected in %d place(s) (- expected, + got)", len(diffs)), diffs: diffs}
+	}
+	return caseResult{}
+}
+
+// caseDoc returns an inline document, or reads file (relative to dir unless absolute).
+func caseDoc(dir string, inline *migrate.Object, file string) (*migrate.Object, error) {
+	if inline != nil {
+		return inline, nil
+	}
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, file)
+	}
+	return readConfig(path, inputCodec(path, ""))
+}
+
+// message describes why r did not pass.
+func (r caseResult) message() string {
+	if r.err != nil {
+		return "error: " + r.err.Error() + "\n"
+	}
+	var b bytes.Buffer
+	b.WriteString(r.failure + "\n")
+	printDiff(&b, r.diffs)
+	return b.String()
+}
+
+func indent(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = prefix + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// JUnit XML, one test suite per test file.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func writeJUnit(path string, results []caseResult) error {
+	var out junitSuites
+	index := map[string]int{} // test file -> position in out.Suites
+	elapsed := map[string]time.Duration{}
+	for _, r := range results {
+		i, ok := index[r.file]
+		if !ok {
+			i = len(out.Suites)
+			index[r.file] = i
+			out.Suites = append(out.Suites, junitSuite{Name: r.file})
+		}
+		s := &out.Suites[i]
+		c := junitCase{Name: r.name, Classname: r.file, Time: seconds(r.elapsed)}
+		switch {
+		case r.err != nil:
+			c.Error = &junitProblem{Message: r.err.Error(), Body: r.message()}
+			s.Errors++
+		case r.failure != "":
+			c.Failure = &junitProblem{Message: r.failure, Body: r.message()}
+			s.Failures++
+		}
+		s.Tests++
+		s.Cases = append(s.Cases, c)
+		elapsed[r.file] += r.elapsed
+		s.Time = seconds(elapsed[r.file])
+	}
+	b, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0o644)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
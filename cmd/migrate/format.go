@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// formatPatch is the output-only format that writes an RFC 6902 JSON Patch.
+const formatPatch = "patch"
+
+// inputCodec picks the codec to decode path with: its extension, else the
+// --format flag if that names a document format, else JSON.
+func inputCodec(path, flagFormat string) migrate.Codec {
+	if c, ok := migrate.CodecForPath(path); ok {
+		return c
+	}
+	if c, err := migrate.CodecByName(flagFormat); err == nil {
+		return c
+	}
+	return migrate.JSON
+}
+
+// outputFormat picks the format to write: the --format flag, else the output
+// file's extension, else whatever the input was.
+func outputFormat(out, flagFormat string, in migrate.Codec) string {
+	if flagFormat != "" {
+		return flagFormat
+	}
+	if out != "-" {
+		if c, ok := migrate.CodecForPath(out); ok {
+			return c.Name()
+		}
+	}
+	return in.Name()
+}
+
+// validFormat reports whether --format names something we can write.
+func validFormat(name string) bool {
+	if name == "" || name == formatPatch {
+		return true
+	}
+	_, err := migrate.CodecByName(name)
+	return err == nil
+}
+
+// readConfig decodes the config at path; "-" reads stdin.
+func readConfig(path string, codec migrate.Codec) (*migrate.Object, error) {
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := codec.Decode(raw)
+	if err != nil {
+		if path == "-" {
+			path = "stdin"
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// encodeDoc writes doc in the named format; pretty only affects JSON.
+func encodeDoc(doc *migrate.Object, format string, pretty bool) ([]byte, error) {
+	codec, err := migrate.CodecByName(format)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := codec.(migrate.JSONCodec); ok && !pretty {
+		codec = migrate.JSONCodec{}
+	}
+	return codec.Encode(doc)
+}
+
+func encodePatch(ops []migrate.PatchOp, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(ops, "", "  ")
+	}
+	return json.Marshal(ops)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandGlob lists the files matching pattern. It supports filepath.Match
+// syntax in each path segment plus "**", which matches any number of
+// directories. It also returns the directory the walk started from: the
+// pattern's leading segments that hold no wildcards.
+func expandGlob(pattern string) (root string, files []string, err error) {
+	segs := strings.Split(filepath.ToSlash(pattern), "/")
+	n := 0
+	for n < len(segs)-1 && !hasMeta(segs[n]) {
+		n++
+	}
+	if !hasMeta(segs[n]) {
+		// a plain file name
+		if _, err := os.Stat(pattern); err != nil {
+			return "", nil, err
+		}
+		return filepath.Dir(pattern), []string{pattern}, nil
+	}
+	for _, s := range segs[n:] {
+		if _, err := filepath.Match(s, ""); err != nil {
+			return "", nil, err
+		}
+	}
+	root = strings.Join(segs[:n], "/")
+	switch {
+	case n == 0:
+		root = "."
+	case root == "":
+		root = "/"
+	}
+	root = filepath.FromSlash(root)
+	rest := segs[n:]
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if matchSegments(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return root, files, err
+}
+
+func hasMeta(seg string) bool {
+	return strings.ContainsAny(seg, `*?[\`)
+}
+
+// matchSegments matches a path, split into segments, against a pattern split
+// the same way.
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// rewriteInPlace replaces the file at path with convert's output. The file is
+// locked for the whole read-convert-write cycle, and the new content goes to a
+// temporary file that is renamed over the original, so a crash leaves either
+// the old or the new config, never half of one. The original's mode bits are
+// kept; with backup the original content is also kept as path + ".bak".
+func rewriteInPlace(path string, backup bool, convert func(raw []byte) ([]byte, error)) error {
+	// replace the file a symlink points to, not the symlink
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	f, unlock, err := lockConfig(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer unlock()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	enc, err := convert(raw)
+	if err != nil {
+		return err
+	}
+	if backup {
+		if err := writeAtomic(path+".bak", raw, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return writeAtomic(path, enc, info.Mode().Perm())
+}
+
+// lockConfig opens path and locks it. A run that held the lock before us may
+// have renamed a new file over path while we waited, leaving us locking the
+// old one, so after locking, path is checked to still be the file we opened.
+func lockConfig(path string) (*os.File, func(), error) {
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlock, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		opened, err := f.Stat()
+		if err == nil {
+			var cur os.FileInfo
+			if cur, err = os.Stat(path); err == nil && os.SameFile(opened, cur) {
+				return f, unlock, nil
+			}
+		}
+		unlock()
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// over path.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// runLint checks migration files without loading them and prints every
+// issue. It fails only when there are errors; warnings alone pass.
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	scheme := fs.String("scheme", "opaque", "version scheme used to check version ranges: opaque, integer or semver")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"./migrations"}
+	}
+	s, err := migrate.SchemeByName(*scheme)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Usage: migrator lint [--scheme semver] [./migrations ...]")
+		return exitUsage
+	}
+	eng := migrate.NewEngine().WithVersionScheme(s)
+
+	var errs, warnings int
+	for _, dir := range dirs {
+		issues, err := eng.Lint(dir)
+		if err != nil {
+			return report(err)
+		}
+		for _, is := range issues {
+			fmt.Println(is)
+			if is.Severity == migrate.LintError {
+				errs++
+			} else {
+				warnings++
+			}
+		}
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", errs, warnings)
+	if errs > 0 {
+		return exitLoad
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile marks f as in use with a <name>.lock file next to it, since there
+// is no portable advisory lock. A run that finds the lock file fails rather
+// than waits.
+func lockFile(f *os.File) (func(), error) {
+	name := f.Name() + ".lock"
+	lf, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("locked by another run (remove %s if it is stale)", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lf.Close()
+	return func() { os.Remove(name) }, nil
+}
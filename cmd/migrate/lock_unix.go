@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on f, waiting for any other run
+// that holds it.
+func lockFile(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		fmt.Fprintln(os.Stderr, "waiting for lock on", f.Name())
+		err = syscall.Flock(fd, syscall.LOCK_EX)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { syscall.Flock(fd, syscall.LOCK_UN) }, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -11,53 +12,146 @@ import (
 )
 
 func main() {
-	migrationsDir := flag.String("migrations", "./migrations", "directory containing forward migration JSON files")
-	schemasDir := flag.String("schemas", "", "directory containing JSON Schemas (optional)")
-	from := flag.String("from", "", "source version")
-	to := flag.String("to", "", "target version")
-	in := flag.String("in", "", "input config JSON file")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "roundtrip":
+			os.Exit(runRoundtrip(os.Args[2:]))
+		case "batch":
+			os.Exit(runBatch(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "test":
+			os.Exit(runTest(os.Args[2:]))
+		}
+	}
+
+	ef := addEngineFlags(flag.CommandLine)
+	from := flag.String("from", "", "source version (detected from the config when omitted)")
+	to := flag.String("to", "", "target version, or 'latest'")
+	in := flag.String("in", "", "input config file (JSON, YAML or TOML, by extension), or '-' for stdin")
 	out := flag.String("out", "-", "output file ('-' for stdout)")
 	pretty := flag.Bool("pretty", true, "pretty-print JSON")
-	flag.Parse()
+	planOnly := flag.Bool("plan", false, "print the migration plan and exit without reading or writing a config")
+	explain := flag.Bool("explain", false, "print every change each migration step made to stderr")
+	format := flag.String("format", "", "output format: json, yaml, toml or patch (RFC 6902 JSON Patch); defaults to the --out or --in extension")
+	via := flag.String("via", "", "comma-separated versions the migration route must pass through, in order")
+	allowLossy := flag.Bool("allow-lossy", false, "run generated reverses that cannot undo every forward step")
+	inPlace := flag.Bool("in-place", false, "write the migrated config back to --in, atomically and under a file lock")
+	backup := flag.Bool("backup", false, "with --in-place, keep the original config as <in>.bak")
+	ndjson := flag.Bool("ndjson", false, "read one JSON config per line and write one migrated config (or patch) per line; failed lines become {\"$error\": ...} records")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if code, ok := parseFlags(flag.CommandLine, os.Args[1:]); !ok {
+		os.Exit(code)
+	}
 
-	if *from == "" || *to == "" || *in == "" {
-		fmt.Println("Usage: migrator --migrations ./migrations --from 1.0 --to 2.0 --in ./examples/v1_config.json [--out -] [--pretty]")
-		os.Exit(1)
+	// in-place output keeps the input's format
+	badInPlace := *inPlace && (*in == "" || *in == "-" || *out != "-" || *planOnly || (*format != "" && *format != inputCodec(*in, "").Name()))
+	badNDJSON := *ndjson && (*in == "" || *inPlace || *planOnly || (*format != "" && *format != "json" && *format != formatPatch))
+	if *to == "" || (*in == "" && (!*planOnly || *from == "")) || !validFormat(*format) || badInPlace || badNDJSON || (*backup && !*inPlace) {
+		fmt.Fprintln(os.Stderr, "Usage: migrator --migrations ./migrations [--from 1.0] --to 2.0 --in ./examples/v1_config.json|- [--out - | --in-place [--backup]] [--ndjson] [--pretty] [--plan] [--format json|yaml|toml|patch] [--version-field meta/version] [--scheme semver] [--via v3] [--allow-lossy]")
+		fmt.Fprintln(os.Stderr, "       migrator roundtrip --help")
+		fmt.Fprintln(os.Stderr, "       migrator batch --help")
+		fmt.Fprintln(os.Stderr, "       migrator lint [./migrations]")
+		fmt.Fprintln(os.Stderr, "       migrator test --help")
+		os.Exit(exitUsage)
 	}
 
-	eng := migrate.NewEngine()
+	eng, err := ef.load()
+	if err != nil {
+		fail(err)
+	}
 
-	if *schemasDir != "" {
-		v := migrate.NewValidator()
-		if err := v.LoadAll(*schemasDir); err != nil {
-			panic(err)
+	inCodec := inputCodec(*in, *format)
+	opts := migrateOptions(*via, *allowLossy)
+	if *ndjson {
+		*pretty = false // one config per line
+	}
+	detect := func(cfg *migrate.Object) (string, error) {
+		if *from != "" {
+			return *from, nil
 		}
-		eng.WithValidator(v)
+		v, err := eng.DetectVersion(cfg)
+		if err == nil && !*ndjson {
+			fmt.Fprintln(os.Stderr, "detected version:", v)
+		}
+		return v, err
 	}
 
-	if err := eng.LoadAll(*migrationsDir); err != nil {
-		panic(err)
+	if *planOnly {
+		if *from == "" {
+			cfg, err := readConfig(*in, inCodec)
+			if err != nil {
+				fail(err)
+			}
+			if *from, err = detect(cfg); err != nil {
+				fail(err)
+			}
+		}
+		plan, err := eng.Plan(*from, *to, opts...)
+		if err != nil {
+			fail(err)
+		}
+		printPlan(os.Stdout, plan)
+		return
 	}
 
-	raw, err := os.ReadFile(*in)
-	if err != nil {
-		panic(err)
+	// convert migrates a decoded config and encodes the result for output
+	convert := func(cfg *migrate.Object) ([]byte, error) {
+		from, err := detect(cfg)
+		if err != nil {
+			return nil, err
+		}
+		res, err := eng.Migrate(cfg, from, *to, opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range res.Lost {
+			fmt.Fprintf(os.Stderr, "warning: %s leaves %s = %s (step %d (%s) has no inverse)\n", l.Migration, l.Path, compact(l.Value), l.Step, l.Op)
+		}
+		if *explain {
+			printChanges(os.Stderr, res.Changes)
+		}
+		f := outputFormat(*out, *format, inCodec)
+		if f == formatPatch {
+			return encodePatch(res.Patch(), *pretty)
+		}
+		return encodeDoc(res.Doc, f, *pretty)
 	}
-	var cfg map[string]interface{}
-	if err := json.Unmarshal(raw, &cfg); err != nil {
-		panic(err)
+
+	if *inPlace {
+		err := rewriteInPlace(*in, *backup, func(raw []byte) ([]byte, error) {
+			cfg, err := inCodec.Decode(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", *in, err)
+			}
+			return convert(cfg)
+		})
+		if err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(os.Stderr, "migrated", *in, "in place")
+		return
 	}
 
-	outCfg, err := eng.Apply(cfg, *from, *to)
-	if err != nil {
-		panic(err)
+	if *ndjson {
+		lines, failed, err := runNDJSON(*in, *out, convert)
+		if err != nil {
+			fail(err)
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d line(s) failed\n", failed, lines)
+			os.Exit(exitBatch)
+		}
+		return
 	}
 
-	var enc []byte
-	if *pretty {
-		enc, _ = json.MarshalIndent(outCfg, "", "  ")
-	} else {
-		enc, _ = json.Marshal(outCfg)
+	cfg, err := readConfig(*in, inCodec)
+	if err != nil {
+		fail(err)
+	}
+	enc, err := convert(cfg)
+	if err != nil {
+		fail(err)
 	}
 
 	if *out == "-" {
@@ -68,7 +162,120 @@ func main() {
 		return
 	}
 	if err := os.WriteFile(*out, enc, 0o644); err != nil {
-		panic(err)
+		fail(err)
 	}
 	fmt.Fprintln(os.Stderr, "wrote", strings.TrimSpace(*out))
 }
+
+// engineFlags are the flags every subcommand uses to build its engine.
+type engineFlags struct {
+	migrations   *string
+	schemas      *string
+	versionField *string
+	scheme       *string
+}
+
+func addEngineFlags(fs *flag.FlagSet) *engineFlags {
+	return &engineFlags{
+		migrations:   fs.String("migrations", "./migrations", "directory containing forward migration JSON files"),
+		schemas:      fs.String("schemas", "", "directory containing JSON Schemas (optional)"),
+		versionField: fs.String("version-field", "", "slash path of the field holding the config's version, e.g. meta/version"),
+		scheme:       fs.String("scheme", "opaque", "version scheme: opaque, integer or semver"),
+	}
+}
+
+// load builds an engine from the migration and (optional) schema directories.
+func (f *engineFlags) load() (*migrate.Engine, error) {
+	scheme, err := migrate.SchemeByName(*f.scheme)
+	if err != nil {
+		return nil, err
+	}
+	eng := migrate.NewEngine().WithVersionField(*f.versionField).WithVersionScheme(scheme)
+	if *f.schemas != "" {
+		v := migrate.NewValidator()
+		if err := v.LoadAll(*f.schemas); err != nil {
+			return nil, err
+		}
+		eng.WithValidator(v)
+	}
+	if err := eng.LoadAll(*f.migrations); err != nil {
+		return nil, err
+	}
+	return eng, nil
+}
+
+// migrateOptions turns the --via and --allow-lossy flags into engine options.
+func migrateOptions(via string, allowLossy bool) []migrate.Option {
+	var opts []migrate.Option
+	if via != "" {
+		opts = append(opts, migrate.Via(strings.Split(via, ",")...))
+	}
+	if allowLossy {
+		opts = append(opts, migrate.AllowLossy())
+	}
+	return opts
+}
+
+func printPlan(w io.Writer, p *migrate.Plan) {
+	fmt.Fprintf(w, "plan %s -> %s: %s (cost %d)\n", p.From, p.To, strings.Join(p.Chain, " -> "), p.Cost)
+	if len(p.Hops) == 0 {
+		fmt.Fprintln(w, "  nothing to do")
+		return
+	}
+	for _, hop := range p.Hops {
+		name := hop.Migration.Name
+		if name == "" {
+			name = hop.From + "->" + hop.To
+		}
+		mark := ""
+		if hop.Migration.Generated {
+			mark = " [generated reverse]"
+		}
+		if hop.Migration.Lossy {
+			mark += " [lossy]"
+		}
+		fmt.Fprintf(w, "  %s (%s -> %s)%s\n", name, hop.From, hop.To, mark)
+		for i, step := range hop.Migration.Steps {
+			fmt.Fprintf(w, "    %d: %s\n", i, step)
+		}
+		for _, d := range hop.Migration.Dropped {
+			fmt.Fprintf(w, "    dropped forward step %d: %s (%s)\n", d.Index, d.Step, d.Reason)
+		}
+	}
+}
+
+func printChanges(w io.Writer, changes []migrate.Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "no changes")
+		return
+	}
+	for _, c := range changes {
+		if c.Step < 0 {
+			fmt.Fprintf(w, "%s (%s): ", c.Migration, c.Op)
+		} else {
+			fmt.Fprintf(w, "%s step %d (%s): ", c.Migration, c.Step, c.Op)
+		}
+		switch c.Kind {
+		case migrate.ChangeAdd:
+			fmt.Fprintf(w, "add %s = %s\n", c.Path, compact(c.After))
+		case migrate.ChangeRemove:
+			fmt.Fprintf(w, "remove %s (was %s)\n", c.Path, compact(c.Before))
+		case migrate.ChangeMove:
+			fmt.Fprintf(w, "move %s -> %s = %s", c.From, c.Path, compact(c.After))
+			if c.Before != nil {
+				fmt.Fprintf(w, " (overwrote %s)", compact(c.Before))
+			}
+			fmt.Fprintln(w)
+		default:
+			fmt.Fprintf(w, "%s %s: %s -> %s\n", c.Kind, c.Path, compact(c.Before), compact(c.After))
+		}
+	}
+}
+
+func compact(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
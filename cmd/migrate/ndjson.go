@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// runNDJSON migrates every line of the file in ("-" for stdin) as a separate
+// JSON config and writes each result as one line to out ("-" for stdout).
+// Output line N always belongs to input line N: blank lines stay blank, and a
+// line that fails becomes an error record (see errorRecord), is reported on
+// stderr too, and the lines after it still run. It returns how many configs
+// it read and how many of them failed.
+func runNDJSON(in, out string, convert func(*migrate.Object) ([]byte, error)) (lines, failed int, err error) {
+	r := io.Reader(os.Stdin)
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	w := io.Writer(os.Stdout)
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for n := 1; ; n++ {
+		line, rerr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var enc []byte
+			if len(bytes.TrimSpace(line)) > 0 {
+				lines++
+				var err error
+				if enc, err = convertLine(line, convert); err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "line %d: %s\n", n, describeError(err))
+					enc = errorRecord(n, err)
+				}
+			}
+			bw.Write(enc)
+			bw.WriteByte('\n')
+			// flush per line so downstream tools in a pipeline see results as they come
+			if err := bw.Flush(); err != nil {
+				return lines, failed, err
+			}
+		}
+		if rerr == io.EOF {
+			return lines, failed, nil
+		}
+		if rerr != nil {
+			return lines, failed, rerr
+		}
+	}
+}
+
+func convertLine(line []byte, convert func(*migrate.Object) ([]byte, error)) ([]byte, error) {
+	cfg, err := migrate.JSON.Decode(line)
+	if err != nil {
+		return nil, err
+	}
+	return convert(cfg)
+}
+
+// errorRecord is the output line for an input line that failed:
+// {"$error": {"line": 3, "code": 4, "message": "..."}}, with the exit code
+// the failure would have had on its own. Configs are objects without a
+// "$error" key, so consumers can tell the two apart.
+func errorRecord(line int, err error) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep "->" readable
+	enc.Encode(map[string]interface{}{
+		"$error": map[string]interface{}{"line": line, "code": exitCode(err), "message": err.Error()},
+	})
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/repsejnworb/config-migrator/pkg/migrate"
+)
+
+// runRoundtrip migrates a config forward and back again and diffs the result
+// against the original.
+func runRoundtrip(args []string) int {
+	fs := flag.NewFlagSet("roundtrip", flag.ContinueOnError)
+	ef := addEngineFlags(fs)
+	from := fs.String("from", "", "source version (detected from the config when omitted)")
+	to := fs.String("to", "", "version to migrate to and back from, or 'latest'")
+	in := fs.String("in", "", "input config file (JSON, YAML or TOML, by extension)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *to == "" || *in == "" {
+		fmt.Fprintln(os.Stderr, "Usage: migrator roundtrip --migrations ./migrations [--from v1] --to v2 --in cfg.json")
+		return exitUsage
+	}
+
+	eng, err := ef.load()
+	if err != nil {
+		return report(err)
+	}
+	cfg, err := readConfig(*in, inputCodec(*in, ""))
+	if err != nil {
+		return report(err)
+	}
+	if *from == "" {
+		if *from, err = eng.DetectVersion(cfg); err != nil {
+			return report(err)
+		}
+	}
+
+	// lossy reverses are allowed: showing what they lose is the point
+	fwd, err := eng.Migrate(cfg, *from, *to, migrate.AllowLossy())
+	if err != nil {
+		return report(err)
+	}
+	// the return trip starts from the resolved target, never from "latest"
+	back, err := eng.Migrate(fwd.Doc, fwd.Plan.To, *from, migrate.AllowLossy())
+	if err != nil {
+		return report(err)
+	}
+
+	diffs := migrate.Diff(cfg, back.Doc)
+	route := fmt.Sprintf("%s -> %s -> %s", *from, fwd.Plan.To, *from)
+	if len(diffs) == 0 {
+		fmt.Printf("roundtrip %s: OK\n", route)
+		return 0
+	}
+	fmt.Printf("roundtrip %s: %d difference(s)\n", route, len(diffs))
+	printDiff(os.Stdout, diffs)
+	return exitMismatch
+}
+
+func printDiff(w io.Writer, diffs []migrate.Difference) {
+	for _, d := range diffs {
+		switch d.Kind {
+		case migrate.DiffAdded:
+			fmt.Fprintf(w, "  + %s: %s\n", d.Path, compact(d.New))
+		case migrate.DiffRemoved:
+			fmt.Fprintf(w, "  - %s: %s\n", d.Path, compact(d.Old))
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", d.Path, compact(d.Old), compact(d.New))
+		}
+	}
+}
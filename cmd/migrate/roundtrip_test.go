@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs fn and returns what it wrote to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundtripToLatest(t *testing.T) {
+	dir := t.TempDir()
+	migs := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(migs, "1_to_2.json"), `{"name": "1_to_2", "from": "1", "to": "2", "steps": [{"op": "move", "from": "a", "to": "b"}]}`)
+	writeFile(t, filepath.Join(migs, "2_to_3.json"), `{"name": "2_to_3", "from": "2", "to": "3", "steps": [{"op": "move", "from": "b", "to": "c"}]}`)
+	in := filepath.Join(dir, "cfg.json")
+	writeFile(t, in, `{"a": 1}`)
+
+	var code int
+	out := captureStdout(t, func() {
+		code = runRoundtrip([]string{"--migrations", migs, "--scheme", "integer", "--from", "1", "--to", "latest", "--in", in})
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0; output:\n%s", code, out)
+	}
+	if want := "roundtrip 1 -> 3 -> 1: OK"; !strings.Contains(out, want) {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
@@ -3,3 +3,7 @@ module github.com/repsejnworb/config-migrator
 go 1.25.0
 
 require github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/BurntSushi/toml v1.6.0
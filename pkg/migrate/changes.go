@@ -0,0 +1,187 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change kinds, named after the JSON Patch operation that replays them.
+const (
+	ChangeAdd     = "add"
+	ChangeReplace = "replace"
+	ChangeRemove  = "remove"
+	ChangeMove    = "move"
+)
+
+// Change records one concrete modification a migration step made to the document.
+// Paths are concrete (wildcards expanded); Before/After are snapshots taken when
+// the step ran. Replaying the changes in order on the input yields the output.
+// The version stamp written after a hop has Op "version" and Step -1.
+type Change struct {
+	Migration string      `json:"migration"`
+	Step      int         `json:"step"`
+	Op        string      `json:"op"`
+	Kind      string      `json:"kind"`
+	Path      string      `json:"path"`
+	From      string      `json:"from,omitempty"` // source path of a move
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+}
+
+// changeLog mutates a document through the path helpers and records what changed.
+type changeLog struct {
+	root    *Object
+	changes []Change
+}
+
+func (l *changeLog) record(c Change) {
+	l.changes = append(l.changes, c)
+}
+
+// set writes a copy of val at path, so values taken from migration rules are
+// never shared between documents. Missing parent objects are created as in
+// setAtPath and recorded as a single add at the top-most missing ancestor.
+func (l *changeLog) set(path string, val interface{}) error {
+	path = normPath(path)
+	before, existed, err := getAtPath(l.root, path)
+	if err != nil {
+		return err
+	}
+	if existed && valuesEqual(before, val) {
+		return nil
+	}
+	at := path
+	if !existed {
+		at = firstMissing(l.root, path)
+	}
+	val = copyValue(val)
+	if err := setAtPath(l.root, path, val); err != nil {
+		return err
+	}
+	if existed {
+		l.record(Change{Kind: ChangeReplace, Path: path, Before: copyValue(before), After: copyValue(val)})
+		return nil
+	}
+	after, _, _ := getAtPath(l.root, at)
+	l.record(Change{Kind: ChangeAdd, Path: at, After: copyValue(after)})
+	return nil
+}
+
+// delete removes the map key at path; a missing path is not a change.
+func (l *changeLog) delete(path string) error {
+	path = normPath(path)
+	before, ok, err := getAtPath(l.root, path)
+	if err != nil || !ok {
+		return err
+	}
+	if err := deleteAtPath(l.root, path); err != nil {
+		return err
+	}
+	l.record(Change{Kind: ChangeRemove, Path: path, Before: copyValue(before)})
+	return nil
+}
+
+// move relocates the value at from, which must exist, to to. The destination
+// may not be from itself or lie below it.
+func (l *changeLog) move(from, to string) error {
+	from, to = normPath(from), normPath(to)
+	if err := checkMoveTarget(from, to); err != nil {
+		return err
+	}
+	v, ok, err := getAtPath(l.root, from)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("move: source not found %s", from)
+	}
+	before, existed, err := getAtPath(l.root, to)
+	if err != nil {
+		return err
+	}
+	if !existed && firstMissing(l.root, to) != to {
+		// the destination's parents are created on the fly, which a plain move
+		// cannot express; record it as add + remove instead
+		if err := l.set(to, v); err != nil {
+			return err
+		}
+		return l.delete(from)
+	}
+	c := Change{Kind: ChangeMove, From: from, Path: to, After: copyValue(v)}
+	if existed {
+		c.Before = copyValue(before)
+	}
+	if err := moveAtPath(l.root, from, to); err != nil {
+		return err
+	}
+	l.record(c)
+	return nil
+}
+
+// checkMoveTarget rejects a move onto its own source or into it, which would
+// nest a value inside itself.
+func checkMoveTarget(from, to string) error {
+	from, to = normPath(from), normPath(to)
+	if from == "" || to == from || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("move: cannot move %s into itself (to=%s)", from, to)
+	}
+	return nil
+}
+
+// normPath drops empty segments so recorded paths are canonical.
+func normPath(path string) string {
+	return strings.Join(split(path), "/")
+}
+
+// firstMissing returns the shortest prefix of path that does not exist in root.
+func firstMissing(root *Object, path string) string {
+	segs := split(path)
+	for i := 1; i <= len(segs); i++ {
+		prefix := strings.Join(segs[:i], "/")
+		if _, ok, _ := getAtPath(root, prefix); !ok {
+			return prefix
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// copyValue deep-copies a document value into the ordered model. Scalars keep
+// their Go types (int64, time.Time, ...) so codecs can write them back
+// unchanged; plain maps become objects with sorted keys, and composite values
+// of other types are normalized through JSON.
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case *Object:
+		out := &Object{keys: append([]string(nil), x.keys...), vals: make(map[string]interface{}, len(x.vals))}
+		for k, val := range x.vals {
+			out.vals[k] = copyValue(val)
+		}
+		return out
+	case map[string]interface{}:
+		return sortedObject(x, copyValue)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = copyValue(val)
+		}
+		return out
+	case nil:
+		return nil
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		out, err := decodeOrdered(newDecoder(bytes.NewReader(b)))
+		if err != nil {
+			return v
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,473 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec decodes configuration files into documents the engine can migrate and
+// encodes migrated documents back. Decoded documents only contain *Object,
+// []interface{} and scalars, and keep the key order of the file.
+type Codec interface {
+	Name() string
+	Extensions() []string
+	Decode(data []byte) (*Object, error)
+	Encode(doc *Object) ([]byte, error)
+}
+
+// Built-in codecs.
+var (
+	JSON Codec = JSONCodec{Indent: "  "}
+	YAML Codec = yamlCodec{}
+	TOML Codec = tomlCodec{}
+)
+
+var codecs = []Codec{JSON, YAML, TOML}
+
+// CodecByName returns the built-in codec called name ("json", "yaml" or "toml").
+func CodecByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config format %q", name)
+}
+
+// CodecForPath picks a built-in codec by file extension.
+func CodecForPath(path string) (Codec, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, c := range codecs {
+		for _, e := range c.Extensions() {
+			if e == ext {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// JSONCodec reads and writes JSON; an empty Indent writes compact output.
+type JSONCodec struct {
+	Indent string
+}
+
+func (JSONCodec) Name() string         { return "json" }
+func (JSONCodec) Extensions() []string { return []string{".json"} }
+
+func (JSONCodec) Decode(data []byte) (*Object, error) {
+	return decodeOrderedDocument(bytes.NewReader(data))
+}
+
+func (c JSONCodec) Encode(doc *Object) ([]byte, error) {
+	if c.Indent == "" {
+		return json.Marshal(doc)
+	}
+	return json.MarshalIndent(doc, "", c.Indent)
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Name() string         { return "yaml" }
+func (yamlCodec) Extensions() []string { return []string{".yaml", ".yml"} }
+
+func (yamlCodec) Decode(data []byte) (*Object, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == 0 {
+		return NewObject(), nil // empty file
+	}
+	v, err := fromYAMLNode(&root)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := v.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("top-level YAML value must be a mapping, got %T", v)
+	}
+	return doc, nil
+}
+
+func (yamlCodec) Encode(doc *Object) ([]byte, error) {
+	node, err := toYAMLNode(doc)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fromYAMLNode converts a YAML node into the shapes encoding/json produces, so
+// migrations and path helpers see the same document either way: mappings
+// become *Object (keys as written, in order) and numbers become json.Number.
+// Floats JSON cannot spell (.inf, .nan, 1.) stay float64, and timestamps stay
+// time.Time so they are written back as timestamps.
+func fromYAMLNode(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return fromYAMLNode(n.Content[0])
+	case yaml.AliasNode:
+		return fromYAMLNode(n.Alias)
+	case yaml.MappingNode:
+		obj := NewObject()
+		var merges []*yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Tag == "!!merge" {
+				merges = append(merges, v)
+				continue
+			}
+			if _, dup := obj.Get(k.Value); dup {
+				return nil, fmt.Errorf("line %d: mapping key %q is duplicated", k.Line, k.Value)
+			}
+			val, err := fromYAMLNode(v)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(k.Value, val)
+		}
+		// "<<: *base" merges keys the mapping does not set itself
+		for _, m := range merges {
+			srcs := []*yaml.Node{m}
+			if m.Kind == yaml.SequenceNode {
+				srcs = m.Content
+			}
+			for _, src := range srcs {
+				mv, err := fromYAMLNode(src)
+				if err != nil {
+					return nil, err
+				}
+				mo, ok := mv.(*Object)
+				if !ok {
+					return nil, fmt.Errorf("line %d: merge value must be a mapping", m.Line)
+				}
+				for _, k := range mo.keys {
+					if _, ok := obj.Get(k); !ok {
+						obj.Set(k, mo.vals[k])
+					}
+				}
+			}
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		arr := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := fromYAMLNode(c)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch x := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(x)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(x, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(x, 10)), nil
+	case float64:
+		if isJSONNumber(n.Value) {
+			return json.Number(n.Value), nil
+		}
+		return x, nil
+	case time.Time:
+		return yamlTime(x, n.Value), nil
+	}
+	return v, nil
+}
+
+// Locations for YAML timestamps written without an offset, named like TOML's
+// local kinds so they are written back the way they were read.
+var (
+	yamlLocalDatetime = time.FixedZone("datetime-local", 0)
+	yamlLocalDate     = time.FixedZone("date-local", 0)
+)
+
+// yamlTime marks a timestamp the YAML decoder read as UTC when its literal
+// has no offset: a date alone, or a date and time separated by a space.
+func yamlTime(t time.Time, literal string) time.Time {
+	if !strings.ContainsAny(literal, "Tt ") {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, yamlLocalDate)
+	}
+	if _, err := time.Parse("2006-1-2 15:4:5.999999999", literal); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), yamlLocalDatetime)
+	}
+	return t
+}
+
+// isJSONNumber reports whether s is a number literal as JSON writes it.
+func isJSONNumber(s string) bool {
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+// toYAMLNode builds a YAML node tree that keeps the document's key order.
+func toYAMLNode(v interface{}) (*yaml.Node, error) {
+	switch x := v.(type) {
+	case *Object:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range x.keys {
+			val, err := toYAMLNode(x.vals[k])
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, val)
+		}
+		return n, nil
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range x {
+			val, err := toYAMLNode(item)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, val)
+		}
+		return n, nil
+	case json.Number:
+		// a json.Number is a string to the YAML encoder; an untagged scalar
+		// writes the literal as it is, and it reads back as a number
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: x.String()}, nil
+	case time.Time:
+		// untagged, so it reads back as a timestamp; YAML spells a local
+		// date-time with a space
+		s := formatTime(x)
+		if x.Location().String() == "datetime-local" {
+			s = x.Format("2006-01-02 15:04:05.999999999")
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: s}, nil
+	}
+	n := &yaml.Node{}
+	if err := n.Encode(v); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// localLayouts maps the marker locations BurntSushi/toml gives local
+// date-times, dates and times (it keeps the locations themselves internal) to
+// the layouts they are written in. They have no offset, so other formats get
+// them without one rather than with a made-up UTC offset.
+var localLayouts = map[string]string{
+	"datetime-local": "2006-01-02T15:04:05.999999999",
+	"date-local":     "2006-01-02",
+	"time-local":     "15:04:05.999999999",
+}
+
+// formatTime writes a datetime value for JSON and YAML: local kinds in their
+// own layout, anything else as RFC 3339.
+func formatTime(t time.Time) string {
+	if layout, ok := localLayouts[t.Location().String()]; ok {
+		return t.Format(layout)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// jsonValue prepares a value for encoding/json, which would write every
+// time.Time as RFC 3339. Objects take care of their own values.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		return formatTime(x)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = jsonValue(val)
+		}
+		return out
+	}
+	return v
+}
+
+// tomlCodec keeps TOML's own scalar types: integers stay int64 and all four
+// datetime kinds stay time.Time (local ones carry a marker location), so they
+// are written back exactly as they were read.
+type tomlCodec struct{}
+
+func (tomlCodec) Name() string         { return "toml" }
+func (tomlCodec) Extensions() []string { return []string{".toml"} }
+
+func (tomlCodec) Decode(data []byte) (*Object, error) {
+	var raw map[string]interface{}
+	md, err := toml.Decode(string(data), &raw)
+	if err != nil {
+		return nil, err
+	}
+	// md.Keys lists every key in file order; remember each table's key order
+	order := map[string][]string{}
+	for _, k := range md.Keys() {
+		parent := strings.Join(k[:len(k)-1], "\x00")
+		order[parent] = appendUnique(order[parent], k[len(k)-1])
+	}
+	return fromTOML(raw, nil, order).(*Object), nil
+}
+
+func (tomlCodec) Encode(doc *Object) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	v, err := toTOML(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fromTOML builds ordered objects from decoded TOML, turning arrays of tables
+// ([]map[string]interface{}) into the []interface{} the path helpers walk.
+// Tables use the key order recorded for their path; keys missing from it are
+// appended sorted.
+func fromTOML(v interface{}, path []string, order map[string][]string) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		obj := NewObject()
+		for _, k := range order[strings.Join(path, "\x00")] {
+			if val, ok := x[k]; ok {
+				obj.Set(k, fromTOML(val, append(path, k), order))
+			}
+		}
+		rest := sortedObject(x, func(v interface{}) interface{} { return v })
+		for _, k := range rest.keys {
+			if _, ok := obj.Get(k); !ok {
+				obj.Set(k, fromTOML(x[k], append(path, k), order))
+			}
+		}
+		return obj
+	case []map[string]interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = fromTOML(val, path, order)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = fromTOML(val, path, order)
+		}
+		return out
+	}
+	return v
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, x := range list {
+		if x == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// toTOML prepares a document for the TOML encoder. The encoder sorts map keys
+// but writes struct fields in declaration order, so each object becomes a
+// struct built on the fly with one field per key. TOML has no null; nil
+// values are left out.
+func toTOML(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case *Object:
+		var fields []reflect.StructField
+		var vals []interface{}
+		plain := map[string]interface{}{}
+		for _, k := range x.keys {
+			if x.vals[k] == nil {
+				continue
+			}
+			val, err := toTOML(x.vals[k])
+			if err != nil {
+				return nil, err
+			}
+			plain[k] = val
+			fields = append(fields, reflect.StructField{
+				Name: "F" + strconv.Itoa(len(fields)),
+				Type: reflect.TypeOf((*interface{})(nil)).Elem(),
+				Tag:  reflect.StructTag("toml:" + strconv.Quote(k)),
+			})
+			vals = append(vals, val)
+		}
+		for k := range plain {
+			if strings.Contains(k, ",") {
+				// the encoder would read the comma as a tag option; fall back to a map
+				return plain, nil
+			}
+		}
+		sv := reflect.New(reflect.StructOf(fields)).Elem()
+		for i, val := range vals {
+			sv.Field(i).Set(reflect.ValueOf(&val).Elem())
+		}
+		return sv.Interface(), nil
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			var err error
+			if out[i], err = toTOML(val); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case json.Number:
+		return tomlNumber(x)
+	case time.Time:
+		// the encoder only knows its own local locations, not those of YAML times
+		if _, local := localLayouts[x.Location().String()]; local {
+			return tomlLiteral(formatTime(x)), nil
+		}
+	}
+	return v, nil
+}
+
+// tomlNumber converts a JSON number for the encoder, which would quietly write
+// an integer too large for int64 as a float. Integers must fit in int64;
+// numbers written with a fraction or exponent become float64.
+func tomlNumber(n json.Number) (interface{}, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("toml: integer %s does not fit in 64 bits", s)
+		}
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("toml: number %s is out of range for a 64-bit float", s)
+	}
+	return f, nil
+}
+
+// tomlLiteral is written to TOML as it is, unquoted.
+type tomlLiteral string
+
+func (l tomlLiteral) MarshalTOML() ([]byte, error) { return []byte(l), nil }
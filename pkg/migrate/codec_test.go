@@ -0,0 +1,95 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCodecGolden decodes each input file and checks that every codec writes
+// it as the golden file in testdata.
+func TestCodecGolden(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		// TOML's local date-times, dates and times keep their layout, without an offset
+		{"local.toml", "local.toml"},
+		{"local.toml", "local.json"},
+		{"local.toml", "local.yaml"},
+		// YAML timestamps stay timestamps, and dates stay dates
+		{"dates.yaml", "dates.yaml"},
+		{"dates.yaml", "dates.json"},
+		{"dates.yaml", "dates.toml"},
+		{"dates.toml", "dates.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+"->"+tt.out, func(t *testing.T) {
+			doc := readGolden(t, tt.in)
+			codec, _ := CodecForPath(tt.out)
+			got, err := codec.Encode(doc)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			want, err := os.ReadFile(filepath.Join("testdata", tt.out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(got)) != strings.TrimSpace(string(want)) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func readGolden(t *testing.T, name string) *Object {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, ok := CodecForPath(name)
+	if !ok {
+		t.Fatalf("no codec for %s", name)
+	}
+	doc, err := codec.Decode(b)
+	if err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	return doc
+}
+
+func TestTOMLNumbers(t *testing.T) {
+	tests := []struct {
+		json, want, wantErr string
+	}{
+		{`{"n": 9223372036854775807}`, "n = 9223372036854775807", ""},
+		{`{"n": -9223372036854775808}`, "n = -9223372036854775808", ""},
+		{`{"n": 1.5}`, "n = 1.5", ""},
+		{`{"n": 1e3}`, "n = 1000.0", ""},
+		{`{"n": 9223372036854775808}`, "", "integer 9223372036854775808 does not fit in 64 bits"},
+		{`{"a": [{"n": 123456789012345678901234567890}]}`, "", "does not fit in 64 bits"},
+		{`{"a,b": 99999999999999999999}`, "", "does not fit in 64 bits"},
+		{`{"n": 1e999}`, "", "out of range for a 64-bit float"},
+	}
+	for _, tt := range tests {
+		doc, err := JSON.Decode([]byte(tt.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := TOML.Encode(doc)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("encode %s: error = %v, want %q", tt.json, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("encode %s: %v", tt.json, err)
+			continue
+		}
+		if strings.TrimSpace(string(got)) != tt.want {
+			t.Errorf("encode %s = %q, want %q", tt.json, got, tt.want)
+		}
+	}
+}
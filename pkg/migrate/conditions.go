@@ -0,0 +1,377 @@
+package migrate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConditionFunc reports whether cur, the value a rule looks at, satisfies a
+// predicate. arg is the predicate's argument from the migration, e.g. "debug"
+// in {"if": {"equals": "debug"}}.
+type ConditionFunc func(cur, arg interface{}) bool
+
+// ConditionInverter turns a condition {"if": {name: arg}, "then": then} into
+// the one a generated reverse uses: pred is the reverse's "if" and value its
+// "then". It returns false when the condition cannot be reversed.
+type ConditionInverter func(arg, then interface{}) (pred map[string]interface{}, value interface{}, ok bool)
+
+type condition struct {
+	match  ConditionFunc
+	invert ConditionInverter
+	check  func(arg interface{}) error // validates arg for lint; nil accepts anything
+}
+
+// Combinators join other predicates: {"allOf": [...]}, {"anyOf": [...]} and
+// {"not": {...}}. Several keys in one predicate object are also ORed, as if
+// they were listed in anyOf. "path" is not a predicate but picks the value the
+// others in its object test; see matchesCondition.
+const (
+	condAllOf = "allOf"
+	condAnyOf = "anyOf"
+	condNot   = "not"
+	condPath  = "path"
+)
+
+// jsonTypes are the type names the "type" condition accepts.
+var jsonTypes = []string{"null", "boolean", "number", "string", "array", "object"}
+
+// builtinConditions returns the conditions every engine starts with.
+func builtinConditions() map[string]condition {
+	return map[string]condition{
+		"equals": {
+			match: func(cur, arg interface{}) bool { return valuesEqual(cur, arg) },
+			// a value set because it equalled arg goes back to arg
+			invert: func(arg, then interface{}) (map[string]interface{}, interface{}, bool) {
+				return map[string]interface{}{"equals": then}, arg, true
+			},
+		},
+		"notEquals": {
+			match: func(cur, arg interface{}) bool { return !valuesEqual(cur, arg) },
+		},
+		// a value is missing or null, or it exists
+		"exists": {
+			match: func(cur, arg interface{}) bool {
+				want, _ := arg.(bool)
+				return (cur != nil) == want
+			},
+			check: checkArgType[bool]("a boolean"),
+		},
+		"in": {
+			match: func(cur, arg interface{}) bool {
+				list, _ := arg.([]interface{})
+				for _, v := range list {
+					if valuesEqual(cur, v) {
+						return true
+					}
+				}
+				return false
+			},
+			check: checkArgType[[]interface{}]("an array"),
+		},
+		"matches": {
+			match: func(cur, arg interface{}) bool {
+				s, ok := stringValue(cur)
+				if !ok {
+					return false
+				}
+				re, err := compileRegexp(arg)
+				return err == nil && re.MatchString(s)
+			},
+			check: func(arg interface{}) error {
+				_, err := compileRegexp(arg)
+				return err
+			},
+		},
+		"gt":  ordered(func(c int) bool { return c > 0 }),
+		"gte": ordered(func(c int) bool { return c >= 0 }),
+		"lt":  ordered(func(c int) bool { return c < 0 }),
+		"lte": ordered(func(c int) bool { return c <= 0 }),
+		"type": {
+			match: func(cur, arg interface{}) bool { return jsonType(cur) == arg },
+			check: func(arg interface{}) error {
+				if s, ok := arg.(string); !ok || !contains(jsonTypes, s) {
+					return fmt.Errorf("needs one of %s", strings.Join(jsonTypes, ", "))
+				}
+				return nil
+			},
+		},
+		// a substring of a string, or an element of an array
+		"contains": {
+			match: func(cur, arg interface{}) bool {
+				if s, ok := stringValue(cur); ok {
+					sub, ok := arg.(string)
+					return ok && strings.Contains(s, sub)
+				}
+				if list, ok := cur.([]interface{}); ok {
+					for _, v := range list {
+						if valuesEqual(v, arg) {
+							return true
+						}
+					}
+				}
+				return false
+			},
+		},
+		"startsWith": {
+			match: func(cur, arg interface{}) bool {
+				s, ok := stringValue(cur)
+				prefix, ok2 := arg.(string)
+				return ok && ok2 && strings.HasPrefix(s, prefix)
+			},
+			check: checkArgType[string]("a string"),
+		},
+	}
+}
+
+// ordered builds a comparison condition; numbers compare by value and strings
+// lexically, and anything else never matches.
+func ordered(want func(cmp int) bool) condition {
+	return condition{
+		match: func(cur, arg interface{}) bool {
+			c, ok := compareValues(cur, arg)
+			return ok && want(c)
+		},
+		check: func(arg interface{}) error {
+			if _, ok := numberValue(arg); ok {
+				return nil
+			}
+			if _, ok := arg.(string); ok {
+				return nil
+			}
+			return fmt.Errorf("needs a number or a string")
+		},
+	}
+}
+
+func checkArgType[T any](what string) func(arg interface{}) error {
+	return func(arg interface{}) error {
+		if _, ok := arg.(T); !ok {
+			return fmt.Errorf("needs %s", what)
+		}
+		return nil
+	}
+}
+
+// compareValues orders two numbers or two strings; datetimes compare as strings.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := numberValue(a); ok {
+		y, ok := numberValue(b)
+		if !ok {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	}
+	x, ok := stringValue(a)
+	y, ok2 := stringValue(b)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// jsonType names the JSON type of a document value.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string, time.Time:
+		return "string"
+	case *Object, map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if _, ok := numberValue(v); ok {
+		return "number"
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Float32, reflect.Float64:
+		return "number" // NaN and infinities
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// regexps caches compiled "matches" patterns; engines are shared between goroutines.
+var regexps sync.Map // pattern -> *regexp.Regexp
+
+func compileRegexp(arg interface{}) (*regexp.Regexp, error) {
+	pattern, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("needs a regular expression string")
+	}
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+// RegisterCondition adds a predicate that rule conditions can use as
+// {"if": {name: arg}}. invert may be nil, in which case set and mapArray steps
+// whose conditions use the predicate cannot be reversed. Like RegisterOp, call
+// it before loading migrations. Built-in conditions cannot be replaced.
+func (e *Engine) RegisterCondition(name string, fn ConditionFunc, invert ConditionInverter) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("register condition %q: name and function are required", name)
+	}
+	if _, builtin := builtinConditions()[name]; builtin || reservedCondition(name) {
+		return fmt.Errorf("register condition %q: cannot replace a built-in condition", name)
+	}
+	if _, dup := e.conditions[name]; dup {
+		return fmt.Errorf("register condition %q: already registered", name)
+	}
+	e.conditions[name] = condition{match: fn, invert: invert}
+	return nil
+}
+
+// reservedCondition reports whether name is a combinator or "path".
+func reservedCondition(name string) bool {
+	return name == condAllOf || name == condAnyOf || name == condNot || name == condPath
+}
+
+// condScope locates the value a rule is looking at, so that predicates with a
+// "path" can test other values of the document.
+type condScope struct {
+	root *Object
+	at   string // slash path of the rule's target
+	item bool   // at is an array element of a mapArray rule
+}
+
+// matchesCondition reports whether cur satisfies any of the predicates in
+// pred. When pred has a "path", its predicates test the value at that path
+// instead: an absolute path starting with "/" or one relative to the rule's
+// target, where ".." is the parent. An array element is looked at from its
+// fields, so for it ../name and name both mean the element's field name. A
+// missing value tests as null; a path that cannot be resolved is an error.
+func (e *Engine) matchesCondition(scope condScope, cur interface{}, pred map[string]interface{}) (bool, error) {
+	if p, ok := pred[condPath]; ok {
+		v, err := scope.lookup(p)
+		if err != nil {
+			return false, err
+		}
+		cur = v
+	}
+	for key, arg := range pred {
+		if key == condPath {
+			continue
+		}
+		ok, err := e.matchPredicate(scope, cur, key, arg)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// lookup returns the value at a predicate's path, or nil when there is none.
+func (s condScope) lookup(p interface{}) (interface{}, error) {
+	rel, ok := p.(string)
+	if !ok {
+		return nil, fmt.Errorf("condition path must be a string, got %s", jsonType(p))
+	}
+	if s.item && !strings.HasPrefix(rel, "/") {
+		// the element takes the place of the parent a set target's ".." reaches
+		if segs := split(rel); len(segs) > 0 && segs[0] == ".." {
+			rel = strings.Join(segs[1:], "/")
+		}
+	}
+	path, ok := resolveRelative(s.at, rel)
+	if !ok {
+		return nil, fmt.Errorf("condition path %q climbs above the document root", rel)
+	}
+	if hasWildcard(path) {
+		return nil, fmt.Errorf("condition path %q: wildcards not allowed", rel)
+	}
+	v, _, err := getAtPath(s.root, path)
+	if err != nil {
+		return nil, fmt.Errorf("condition path %q: %w", rel, err)
+	}
+	return v, nil
+}
+
+// matchPredicate evaluates one key of a predicate object. Unknown predicates
+// and malformed combinators never match.
+func (e *Engine) matchPredicate(scope condScope, cur interface{}, key string, arg interface{}) (bool, error) {
+	switch key {
+	case condAllOf, condAnyOf:
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, nil
+		}
+		for _, item := range list {
+			sub, ok := item.(map[string]interface{})
+			if !ok {
+				return false, nil
+			}
+			m, err := e.matchesCondition(scope, cur, sub)
+			if err != nil {
+				return false, err
+			}
+			if m == (key == condAnyOf) {
+				return key == condAnyOf, nil
+			}
+		}
+		return key == condAllOf, nil
+	case condNot:
+		sub, ok := arg.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		m, err := e.matchesCondition(scope, cur, sub)
+		return !m && err == nil, err
+	}
+	c, ok := e.conditions[key]
+	return ok && c.match(cur, arg), nil
+}
+
+// invertConditions reverses a rule's conditions for a generated reverse. Each
+// condition must use a single predicate that has an inverter.
+func invertConditions(conds []interface{}, registry map[string]condition) ([]interface{}, bool) {
+	inv := []interface{}{}
+	for _, c := range conds {
+		cMap, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ifCond, ok := cMap["if"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if len(ifCond) != 1 {
+			return nil, false // which of several predicates matched is unknown
+		}
+		for name, arg := range ifCond {
+			c, ok := registry[name]
+			if !ok || c.invert == nil {
+				return nil, false
+			}
+			pred, value, ok := c.invert(arg, cMap["then"])
+			if !ok {
+				return nil, false
+			}
+			inv = append(inv, map[string]interface{}{"if": pred, "then": value})
+		}
+	}
+	return inv, len(inv) > 0
+}
+
+// conditionNames lists the predicate names in pred, sorted.
+func conditionNames(pred map[string]interface{}) []string {
+	names := make([]string, 0, len(pred))
+	for name := range pred {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const condDoc = `{
+  "mode": "prod",
+  "router": {"security": {"enabled": true, "roles": []}},
+  "tags": [{"name": "a"}, {"name": "b"}]
+}`
+
+// TestConditionPaths runs a single step with one condition on condDoc and
+// compares the JSON of the value at check, or the error the step fails with.
+func TestConditionPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    MigrationStep
+		check   string
+		want    string
+		wantErr string
+	}{
+		{"set sibling", setIf("router/security/enabled", `{"path": "../roles", "equals": []}`), "router/security/enabled", `false`, ""},
+		{"set absolute", setIf("router/security/enabled", `{"path": "/mode", "equals": "prod"}`), "router/security/enabled", `false`, ""},
+		{"set no match", setIf("router/security/enabled", `{"path": "/mode", "equals": "dev"}`), "router/security/enabled", `true`, ""},
+		{"set missing is null", setIf("router/security/enabled", `{"path": "../nope", "type": "null"}`), "router/security/enabled", `false`, ""},
+		{"item field via ..", mapIf("tags", `{"path": "../name", "equals": "a"}`), "tags", `[false,{"name":"b"}]`, ""},
+		{"item field", mapIf("tags", `{"path": "name", "equals": "b"}`), "tags", `[{"name":"a"},false]`, ""},
+		{"item itself", mapIf("tags", `{"path": "..", "type": "object"}`), "tags", `[false,false]`, ""},
+		{"item climbs to array", mapIf("tags", `{"path": "../../1/name", "equals": "b"}`), "tags", `[false,false]`, ""},
+		{"item absolute", mapIf("tags", `{"path": "/mode", "equals": "prod"}`), "tags", `[false,false]`, ""},
+
+		{"above root", setIf("mode", `{"path": "../../x", "exists": true}`), "", "", "climbs above the document root"},
+		{"name into array", setIf("mode", `{"path": "/tags/name", "exists": true}`), "", "", `condition path "/tags/name": array index expected`},
+		{"error inside not", setIf("mode", `{"not": {"path": "/tags/name", "exists": true}}`), "", "", "array index expected"},
+		{"item error", mapIf("tags", `{"path": "../../name", "exists": true}`), "", "", "array index expected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			if err := e.addMigration(Migration{From: "1", To: "2", Steps: []MigrationStep{tt.step}}); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := JSON.Decode([]byte(condDoc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := e.Migrate(doc, "1", "2")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Migrate error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, _, _ := getAtPath(res.Doc, tt.check)
+			got, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s = %s, want %s", tt.check, got, tt.want)
+			}
+		})
+	}
+}
+
+// setIf sets path to false when pred matches.
+func setIf(path, pred string) MigrationStep {
+	return MigrationStep{Op: "set", Path: path, Rule: condRule(pred)}
+}
+
+// mapIf replaces the elements of the array at path that match pred with false.
+func mapIf(path, pred string) MigrationStep {
+	return MigrationStep{Op: "mapArray", Path: path, Rule: condRule(pred)}
+}
+
+func condRule(pred string) map[string]interface{} {
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(pred), &p); err != nil {
+		panic(err)
+	}
+	return map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"if": p, "then": false}}}
+}
+
+// YAML decodes dates to time.Time; string predicates and ops see them as the
+// text they are written as.
+func TestDatetimeAsString(t *testing.T) {
+	doc, err := YAML.Decode([]byte("day: 2024-01-02\nat: 2024-01-02T03:04:05Z\ndays: [2024-01-02]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day, _ := doc.Get("day")
+	at, _ := doc.Get("at")
+	conds := builtinConditions()
+	tests := []struct {
+		cur  interface{}
+		pred string
+		arg  interface{}
+	}{
+		{day, "equals", "2024-01-02"},
+		{day, "in", []interface{}{"2023-12-31", "2024-01-02"}},
+		{day, "startsWith", "2024"},
+		{day, "contains", "-01-"},
+		{day, "matches", `^\d{4}-\d{2}-\d{2}$`},
+		{day, "gt", "2023-12-31"},
+		{at, "equals", "2024-01-02T03:04:05Z"},
+		{at, "lt", "2024-01-03"},
+	}
+	for _, tt := range tests {
+		if !conds[tt.pred].match(tt.cur, tt.arg) {
+			t.Errorf("%s %v on %v = false, want true", tt.pred, tt.arg, tt.cur)
+		}
+	}
+
+	e := NewEngine()
+	step := MigrationStep{Op: "mapArray", Path: "days", Rule: map[string]interface{}{"stringToObject": true, "separator": "-"}}
+	if err := e.addMigration(Migration{From: "1", To: "2", Steps: []MigrationStep{step}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Migrate(doc, "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	days, _ := res.Doc.Get("days")
+	if got, _ := json.Marshal(days); string(got) != `[{"2024":true}]` {
+		t.Errorf("days = %s, want [{\"2024\":true}]", got)
+	}
+}
@@ -0,0 +1,95 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// WithVersionField sets the slash path (e.g. "meta/version") that holds a document's version.
+func (e *Engine) WithVersionField(path string) *Engine {
+	e.versionField = path
+	return e
+}
+
+// DetectVersion works out which version doc is in. A version field (the engine's,
+// then any declared by loaded migrations) wins when present; otherwise doc is validated against every loaded schema and
+// exactly one of them must match.
+func (e *Engine) DetectVersion(doc *Object) (string, error) {
+	for _, field := range e.versionFields() {
+		v, ok, err := getAtPath(doc, field)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			s, ok := versionString(v)
+			if !ok {
+				return "", &UnknownVersionError{Reason: fmt.Sprintf("version field %s holds %T", field, v)}
+			}
+			return s, nil
+		}
+	}
+	if e.validator == nil || len(e.validator.schemas) == 0 {
+		return "", &UnknownVersionError{Reason: "no version field in document and no schemas loaded"}
+	}
+	var matches []string
+	for _, version := range e.validator.Versions() {
+		if e.validator.Validate(version, doc) == nil {
+			matches = append(matches, version)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", &UnknownVersionError{Reason: "document matches no loaded schema"}
+	case 1:
+		return matches[0], nil
+	}
+	return "", &AmbiguousVersionError{Candidates: matches}
+}
+
+// versionFields lists the engine's version field followed by those declared by migrations.
+func (e *Engine) versionFields() []string {
+	var out []string
+	seen := map[string]bool{}
+	if e.versionField != "" {
+		out = append(out, e.versionField)
+		seen[e.versionField] = true
+	}
+	var declared []string
+	for _, m := range e.migrations {
+		if m.VersionField != "" && !seen[m.VersionField] {
+			seen[m.VersionField] = true
+			declared = append(declared, m.VersionField)
+		}
+	}
+	sort.Strings(declared)
+	return append(out, declared...)
+}
+
+// versionString accepts string versions and plain numbers such as `"version": 2`.
+func versionString(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, x != ""
+	case json.Number:
+		return x.String(), true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case int:
+		return strconv.Itoa(x), true
+	}
+	return "", false
+}
+
+// versionValue is the value to stamp version with: a number when the field
+// held a number and version reads as one, so schemas expecting an integer
+// still match; otherwise the string.
+func versionValue(old interface{}, version string) interface{} {
+	if _, ok := numberValue(old); ok && isJSONNumber(version) {
+		return json.Number(version)
+	}
+	return version
+}
@@ -0,0 +1,40 @@
+package migrate
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVersionStampKeepsType(t *testing.T) {
+	tests := []struct {
+		name    string
+		current interface{}
+		to      string
+		want    interface{}
+	}{
+		{"number stays number", json.Number("1"), "2", json.Number("2")},
+		{"toml integer", int64(1), "2", json.Number("2")},
+		{"string stays string", "1", "2", "2"},
+		{"non-numeric target", json.Number("1"), "v2", "v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine().WithVersionField("meta/version")
+			if err := e.addMigration(Migration{From: "1", To: tt.to}); err != nil {
+				t.Fatal(err)
+			}
+			doc := NewObject()
+			if err := setAtPath(doc, "meta/version", tt.current); err != nil {
+				t.Fatal(err)
+			}
+			res, err := e.Migrate(doc, "1", tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, _ := getAtPath(res.Doc, "meta/version")
+			if got != tt.want {
+				t.Errorf("version = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package migrate
+
+import "strconv"
+
+// Difference kinds reported by Diff.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// Difference is one structural difference between two documents.
+type Difference struct {
+	Path string
+	Kind string
+	Old  interface{} // unset for DiffAdded
+	New  interface{} // unset for DiffRemoved
+}
+
+// Diff compares two documents and lists where b differs from a. Key order is
+// not a difference: keys are reported in a's order, then keys only b has.
+// Arrays are compared by index.
+func Diff(a, b interface{}) []Difference {
+	var out []Difference
+	diffAt("", a, b, &out)
+	return out
+}
+
+func diffAt(path string, a, b interface{}, out *[]Difference) {
+	join := func(seg string) string {
+		if path == "" {
+			return seg
+		}
+		return path + "/" + seg
+	}
+	if m, ok := a.(map[string]interface{}); ok {
+		a = sortedObject(m, func(v interface{}) interface{} { return v })
+	}
+	if m, ok := b.(map[string]interface{}); ok {
+		b = sortedObject(m, func(v interface{}) interface{} { return v })
+	}
+	switch x := a.(type) {
+	case *Object:
+		y, ok := b.(*Object)
+		if !ok {
+			break
+		}
+		keys := x.Keys()
+		for _, k := range y.keys {
+			if _, ok := x.Get(k); !ok {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			av, inA := x.Get(k)
+			bv, inB := y.Get(k)
+			switch {
+			case !inB:
+				*out = append(*out, Difference{Path: join(k), Kind: DiffRemoved, Old: av})
+			case !inA:
+				*out = append(*out, Difference{Path: join(k), Kind: DiffAdded, New: bv})
+			default:
+				diffAt(join(k), av, bv, out)
+			}
+		}
+		return
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(x) || i < len(y); i++ {
+			switch {
+			case i >= len(y):
+				*out = append(*out, Difference{Path: join(strconv.Itoa(i)), Kind: DiffRemoved, Old: x[i]})
+			case i >= len(x):
+				*out = append(*out, Difference{Path: join(strconv.Itoa(i)), Kind: DiffAdded, New: y[i]})
+			default:
+				diffAt(join(strconv.Itoa(i)), x[i], y[i], out)
+			}
+		}
+		return
+	}
+	if !valuesEqual(a, b) {
+		*out = append(*out, Difference{Path: path, Kind: DiffChanged, Old: a, New: b})
+	}
+}
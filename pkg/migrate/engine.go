@@ -1,12 +1,15 @@
 package migrate
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
-	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,22 +17,17 @@ type Engine struct {
 	migrations map[string]Migration // key: from->to
 	graph      map[string][]string  // adjacency list
 	validator  *Validator           // optional schema validator
-}
 
-type conditionFunc func(cur interface{}, arg interface{}) bool
+	versionField string        // optional path holding the document's version
+	scheme       VersionScheme // orders versions; Opaque unless configured
+	ranges       []Migration   // migrations whose From is a version range, sorted by From
 
-var conditionRegistry = map[string]conditionFunc{
-	"equals": func(cur interface{}, arg interface{}) bool {
-		return reflect.DeepEqual(cur, arg)
-	},
-	"notEquals": func(cur interface{}, arg interface{}) bool {
-		return !reflect.DeepEqual(cur, arg)
-	},
-	// add more here
+	ops        map[string]customOp  // ops added with RegisterOp
+	conditions map[string]condition // built-in conditions plus those added with RegisterCondition
 }
 
 func NewEngine() *Engine {
-	return &Engine{migrations: make(map[string]Migration), graph: make(map[string][]string), validator: nil}
+	return &Engine{migrations: make(map[string]Migration), graph: make(map[string][]string), validator: nil, scheme: Opaque, ops: make(map[string]customOp), conditions: builtinConditions()}
 }
 
 func (e *Engine) WithValidator(v *Validator) *Engine {
@@ -37,35 +35,74 @@ func (e *Engine) WithValidator(v *Validator) *Engine {
 	return e
 }
 
-// LoadAll reads all *.json migrations in dir, registers them, and auto-generates reverse ones.
+// TestSuffix marks files that hold test cases for migrations rather than
+// migrations. They can sit next to the migrations; loaders skip them.
+const TestSuffix = ".test.json"
+
+// LoadAll reads all *.json migrations in dir and its subdirectories, registers them, and auto-generates reverse ones.
 func (e *Engine) LoadAll(dir string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-	for _, ent := range entries {
-		if ent.IsDir() || filepath.Ext(ent.Name()) != ".json" {
-			continue
-		}
-		b, err := os.ReadFile(filepath.Join(dir, ent.Name()))
-		if err != nil {
-			return err
-		}
+	return e.load(os.DirFS(dir), ".", dir)
+}
+
+// LoadFS is LoadAll for migrations under dir in fsys, e.g. an embed.FS.
+// Migrations record their fsys path as Source.
+func (e *Engine) LoadFS(fsys fs.FS, dir string) error {
+	return e.load(fsys, dir, "")
+}
+
+func (e *Engine) load(fsys fs.FS, dir, root string) error {
+	return walkJSONFiles(fsys, dir, root, func(path string, b []byte) error {
 		var m Migration
-		if err := json.Unmarshal(b, &m); err != nil {
-			return fmt.Errorf("%s: %w", ent.Name(), err)
+		if err := newDecoder(bytes.NewReader(b)).Decode(&m); err != nil {
+			return &LoadError{File: path, Err: err}
 		}
+		m.Source = path
 		if err := e.addMigration(m); err != nil {
-			return err
+			return &LoadError{File: path, Err: err}
+		}
+		if isRange(m.From) {
+			return nil // a reverse would have to target a range
 		}
-		fmt.Println("Loaded migration:", m.From, "->", m.To)
 		// auto-generate reverse if possible and not already present
-		rev, err := GenerateReverse(m)
+		rev, err := generateReverse(m, e.invertStep)
 		if err == nil {
 			_ = e.addMigrationIfMissing(rev)
 		}
-	}
-	return nil
+		return nil
+	})
+}
+
+// walkJSONFiles calls fn for every *.json file under dir in fsys, in lexical
+// order, skipping test cases and hidden directories. fn gets the file's fsys
+// path, or with root set, the OS path of the file below root.
+func walkJSONFiles(fsys fs.FS, dir, root string, fn func(path string, data []byte) error) error {
+	return fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		name := p
+		if root != "" {
+			name = filepath.Join(root, filepath.FromSlash(p))
+		}
+		if err != nil {
+			var pe *fs.PathError
+			if errors.As(err, &pe) {
+				err = pe.Err // name already says which file
+			}
+			return &LoadError{File: name, Err: err}
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(p) != ".json" || strings.HasSuffix(p, TestSuffix) {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return &LoadError{File: name, Err: err}
+		}
+		return fn(name, b)
+	})
 }
 
 func (e *Engine) addMigrationIfMissing(m Migration) error {
@@ -80,109 +117,174 @@ func (e *Engine) addMigration(m Migration) error {
 	if m.From == "" || m.To == "" {
 		return errors.New("migration missing from/to")
 	}
+	if isRange(m.To) {
+		return fmt.Errorf("migration target %q must be an exact version", m.To)
+	}
 	key := m.From + "->" + m.To
+	_, exists := e.migrations[key]
 	e.migrations[key] = m
-	e.graph[m.From] = append(e.graph[m.From], m.To)
+	if isRange(m.From) {
+		if exists {
+			for i := range e.ranges {
+				if e.ranges[i].From == m.From && e.ranges[i].To == m.To {
+					e.ranges[i] = m
+				}
+			}
+			return nil
+		}
+		e.ranges = append(e.ranges, m)
+		sort.SliceStable(e.ranges, func(i, j int) bool { return e.ranges[i].From < e.ranges[j].From })
+		return nil
+	}
+	if !exists {
+		e.graph[m.From] = append(e.graph[m.From], m.To)
+	}
 	return nil
 }
 
+// Result is the outcome of Migrate.
+type Result struct {
+	Plan    *Plan
+	Doc     *Object
+	Changes []Change
+	Lost    []LostValue // data left behind by lossy hops, only with AllowLossy
+}
+
 // Apply finds a chain from from->to and applies all migrations in order.
-func (e *Engine) Apply(config map[string]interface{}, from, to string) (map[string]interface{}, error) {
-	if from == to {
-		return deepCopy(config), nil
+// Plain maps carry no key order; use Migrate with an *Object to keep it.
+func (e *Engine) Apply(config map[string]interface{}, from, to string, opts ...Option) (map[string]interface{}, error) {
+	res, err := e.Migrate(FromPlain(config).(*Object), from, to, opts...)
+	if err != nil {
+		return nil, err
 	}
-	chain, err := e.findChain(from, to)
+	return ToPlain(res.Doc).(map[string]interface{}), nil
+}
+
+// Migrate is Apply for ordered documents. It also reports the plan it ran and
+// every change each step made. config itself is not modified. Migrate only
+// reads the engine, so a loaded engine can serve concurrent calls.
+func (e *Engine) Migrate(config *Object, from, to string, opts ...Option) (*Result, error) {
+	plan, err := e.Plan(from, to, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	doc := deepCopy(config)
-	for i := 0; i < len(chain)-1; i++ {
-		a, b := chain[i], chain[i+1]
-		mig, ok := e.migrations[a+"->"+b]
-		if !ok {
-			return nil, fmt.Errorf("missing migration %s->%s", a, b)
+	o := collectOptions(opts)
+	res := &Result{Plan: plan, Doc: deepCopy(config)}
+	if err := e.checkVersion(res.Doc, plan); err != nil {
+		return nil, err
+	}
+	for _, hop := range plan.Hops {
+		changes, lost, err := e.applyMigration(res.Doc, hop)
+		if err != nil {
+			return nil, err
 		}
-		if err := e.applyMigration(doc, mig); err != nil {
-			return nil, fmt.Errorf("apply %s->%s: %w", a, b, err)
+		if hop.Migration.Lossy && !o.allowLossy {
+			return nil, &LossyError{From: hop.From, To: hop.To, Migration: hop.Migration.Name, Dropped: hop.Migration.Dropped, Lost: lost}
 		}
+		res.Lost = append(res.Lost, lost...)
+		res.Changes = append(res.Changes, changes...)
+		if field := e.versionFieldFor(hop.Migration); field != "" {
+			cl := &changeLog{root: res.Doc}
+			old, _, _ := getAtPath(res.Doc, field)
+			if err := cl.set(field, versionValue(old, hop.To)); err != nil {
+				return nil, fmt.Errorf("stamp version %s at %s: %w", hop.To, field, err)
+			}
+			for _, c := range cl.changes {
+				c.Migration, c.Step, c.Op = hop.Migration.Name, -1, "version"
+				res.Changes = append(res.Changes, c)
+			}
+		}
+	}
+	if len(plan.Hops) == 0 {
+		return res, nil
 	}
 	// validate final result against "to" schema if validator present
 	if e.validator != nil {
-		if err := e.validator.Validate(to, doc); err != nil {
+		if err := e.validator.Validate(plan.To, res.Doc); err != nil {
 			return nil, err
 		}
 	}
-	return doc, nil
+	return res, nil
 }
 
-func (e *Engine) findChain(from, to string) ([]string, error) {
-	// BFS
-	type node struct {
-		v    string
-		prev *node
+func (e *Engine) versionFieldFor(m Migration) string {
+	if m.VersionField != "" {
+		return m.VersionField
 	}
-	q := []node{{v: from}}
-	seen := map[string]bool{from: true}
-	var end *node
-	for len(q) > 0 {
-		cur := q[0]
-		q = q[1:]
-		if cur.v == to {
-			end = &cur
-			break
-		}
-		for _, nxt := range e.graph[cur.v] {
-			if !seen[nxt] {
-				seen[nxt] = true
-				q = append(q, node{v: nxt, prev: &cur})
-			}
-		}
+	return e.versionField
+}
+
+// checkVersion rejects a document whose version field names a version other than plan.From.
+func (e *Engine) checkVersion(doc *Object, plan *Plan) error {
+	field := e.versionField
+	if len(plan.Hops) > 0 {
+		field = e.versionFieldFor(plan.Hops[0].Migration)
 	}
-	if end == nil {
-		return nil, fmt.Errorf("no migration path from %s to %s", from, to)
+	if field == "" {
+		return nil
 	}
-	// reconstruct
-	var rev []string
-	for n := end; n != nil; n = n.prev {
-		rev = append(rev, n.v)
+	v, ok, err := getAtPath(doc, field)
+	if err != nil || !ok {
+		return err
 	}
-	// reverse
-	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
-		rev[i], rev[j] = rev[j], rev[i]
+	got, _ := versionString(v)
+	if !e.sameVersion(got, plan.From) {
+		if got == "" {
+			got = fmt.Sprint(v)
+		}
+		return &VersionMismatchError{Field: field, Want: plan.From, Got: got}
 	}
-	return rev, nil
+	return nil
 }
 
-func (e *Engine) applyMigration(doc map[string]interface{}, m Migration) error {
+// applyMigration runs hop's steps on doc. For a lossy hop it also returns
+// what its dropped steps leave behind.
+func (e *Engine) applyMigration(doc *Object, hop PlanHop) ([]Change, []LostValue, error) {
+	m := hop.Migration
+	var changes []Change
+	var lost []LostValue
 	for i, step := range m.Steps {
-		if err := e.applyStep(doc, step); err != nil {
-			return fmt.Errorf("step %d (%s): %w", i, step.Op, err)
+		l, err := lostData(doc, hop, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		lost = append(lost, l...)
+		cl := &changeLog{root: doc}
+		if err := e.applyStep(cl, step); err != nil {
+			se := &StepError{
+				Migration: m.Name, Source: m.Source, From: hop.From, To: hop.To,
+				Step: i, Op: step.Op, Path: stepPath(step), Err: err,
+			}
+			var pe *pathError
+			if errors.As(err, &pe) {
+				se.Path = pe.path
+			}
+			return nil, nil, se
+		}
+		for _, c := range cl.changes {
+			c.Migration, c.Step, c.Op = m.Name, i, step.Op
+			changes = append(changes, c)
 		}
 	}
-	return nil
+	l, err := lostData(doc, hop, len(m.Steps))
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, append(lost, l...), nil
 }
 
-func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error {
+func (e *Engine) applyStep(cl *changeLog, step MigrationStep) error {
+	cfg := cl.root
 	switch step.Op {
 	case "move":
 		if hasWildcard(step.From) || hasWildcard(step.To) {
 			return fmt.Errorf("move does not support wildcards: from=%q to=%q", step.From, step.To)
 		}
-		v, ok, err := getAtPath(cfg, step.From)
-		if err != nil {
+		if err := checkMoveTarget(step.From, step.To); err != nil {
 			return err
 		}
-		if !ok {
-			return fmt.Errorf("move: source not found %s", step.From)
-		}
-		if err := setAtPath(cfg, step.To, v); err != nil {
-			return err
-		}
-		if err := deleteAtPath(cfg, step.From); err != nil {
-			return err
-		}
-		return nil
+		return cl.move(step.From, step.To)
 
 	case "wrap":
 		if hasWildcard(step.Path) {
@@ -195,8 +297,9 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 		if !ok {
 			return fmt.Errorf("wrap: path not found %s", step.Path)
 		}
-		obj := map[string]interface{}{step.WrapAs: v}
-		return setAtPath(cfg, step.Path, obj)
+		obj := NewObject()
+		obj.Set(step.WrapAs, v)
+		return cl.set(step.Path, obj)
 
 	case "unwrap":
 		if hasWildcard(step.Path) || hasWildcard(step.UnwrapTo) {
@@ -209,7 +312,7 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 		if !ok {
 			return fmt.Errorf("unwrap: source not found %s", step.Path)
 		}
-		return setAtPath(cfg, step.UnwrapTo, v)
+		return cl.set(step.UnwrapTo, v)
 
 	case "mapArray":
 		arrays, err := findArrays(cfg, step.Path)
@@ -217,12 +320,15 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 			return err
 		}
 		for _, arr := range arrays {
-			for i := range arr {
-				nv, err := applyItemRule(arr[i], step.Rule)
+			for i := range arr.Items {
+				at := arr.Path + "/" + strconv.Itoa(i)
+				nv, err := e.applyItemRule(condScope{root: cfg, at: at, item: true}, arr.Items[i], step.Rule)
 				if err != nil {
-					return err
+					return &pathError{path: at, err: err}
+				}
+				if err := cl.set(at, nv); err != nil {
+					return &pathError{path: at, err: err}
 				}
-				arr[i] = nv
 			}
 		}
 		return nil
@@ -231,7 +337,7 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 		if hasWildcard(step.Path) {
 			return fmt.Errorf("original_set: wildcards not allowed: %s", step.Path)
 		}
-		return setAtPath(cfg, step.Path, step.Rule["value"]) // use Rule.value for literals
+		return cl.set(step.Path, step.Rule["value"]) // use Rule.value for literals
 
 	case "set":
 		if hasWildcard(step.Path) {
@@ -239,7 +345,13 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 		}
 
 		var newVal interface{}
-		if conds, ok := step.Rule["conditions"].([]interface{}); ok {
+		if src, ok := step.Rule["expr"].(string); ok {
+			v, err := evalExpr(src, cfg, step.Path)
+			if err != nil {
+				return err
+			}
+			newVal = v
+		} else if conds, ok := step.Rule["conditions"].([]interface{}); ok {
 			cur, _, _ := getAtPath(cfg, step.Path)
 			applied := false
 			for _, c := range conds {
@@ -248,7 +360,11 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 					continue
 				}
 				if pred, ok := condMap["if"].(map[string]interface{}); ok {
-					if matchesCondition(cur, pred) {
+					matched, err := e.matchesCondition(condScope{root: cfg, at: step.Path}, cur, pred)
+					if err != nil {
+						return err
+					}
+					if matched {
 						newVal = condMap["then"]
 						applied = true
 						break
@@ -267,45 +383,23 @@ func (e *Engine) applyStep(cfg map[string]interface{}, step MigrationStep) error
 		} else if v, ok := step.Rule["value"]; ok {
 			newVal = v
 		} else {
-			return fmt.Errorf("set: missing 'value' or 'conditions'")
+			return fmt.Errorf("set: missing 'value', 'conditions' or 'expr'")
 		}
 
-		return setAtPath(cfg, step.Path, newVal)
+		return cl.set(step.Path, newVal)
 
 	case "delete":
 		if hasWildcard(step.Path) {
 			return fmt.Errorf("delete: wildcards not allowed: %s", step.Path)
 		}
-		return deleteAtPath(cfg, step.Path)
-	}
-	return fmt.Errorf("unsupported op %q", step.Op)
-}
-
-func old_matchesCondition(val interface{}, cond map[string]interface{}) bool {
-	if eq, ok := cond["equals"]; ok {
-		return val == eq
-	}
-	if neq, ok := cond["notEquals"]; ok {
-		return val != neq
-	}
-	if exists, ok := cond["exists"].(bool); ok {
-		return (val != nil) == exists
-	}
-	return false
-}
-
-func matchesCondition(cur interface{}, pred map[string]interface{}) bool {
-	for key, arg := range pred {
-		if fn, ok := conditionRegistry[key]; ok {
-			if fn(cur, arg) {
-				return true
-			}
-		}
+		return cl.delete(step.Path)
 	}
-	return false
+	return e.applyCustomOp(cl, step)
 }
 
-func applyItemRule(v interface{}, rule map[string]interface{}) (interface{}, error) {
+// applyItemRule converts one array element. scope locates the element for
+// conditions that test other paths.
+func (e *Engine) applyItemRule(scope condScope, v interface{}, rule map[string]interface{}) (interface{}, error) {
 	if rule == nil {
 		return v, nil
 	}
@@ -317,7 +411,7 @@ func applyItemRule(v interface{}, rule map[string]interface{}) (interface{}, err
 		if !ok {
 			val = true
 		}
-		s, ok := v.(string)
+		s, ok := stringValue(v)
 		if !ok {
 			return nil, fmt.Errorf("stringToObject: expected string, got %T", v)
 		}
@@ -326,16 +420,19 @@ func applyItemRule(v interface{}, rule map[string]interface{}) (interface{}, err
 			parts := strings.SplitN(s, sep, 2)
 			key = parts[0]
 		}
-		return map[string]interface{}{key: val}, nil
+		obj := NewObject()
+		obj.Set(key, val)
+		return obj, nil
 	}
 	if b, _ := rule["objectToString"].(bool); b {
 		suf, _ := ruleString(rule, "suffix", "")
-		m, ok := v.(map[string]interface{})
+		m, ok := v.(*Object)
 		if !ok {
 			return nil, fmt.Errorf("objectToString: expected object, got %T", v)
 		}
 		var key string
-		for k, val := range m {
+		for _, k := range m.keys {
+			val := m.vals[k]
 			switch x := val.(type) {
 			case bool:
 				if x {
@@ -363,7 +460,11 @@ func applyItemRule(v interface{}, rule map[string]interface{}) (interface{}, err
 				continue
 			}
 			if pred, ok := cMap["if"].(map[string]interface{}); ok {
-				if matchesCondition(cur, pred) {
+				matched, err := e.matchesCondition(scope, cur, pred)
+				if err != nil {
+					return nil, err
+				}
+				if matched {
 					v = cMap["then"]
 					applied = true
 					break
@@ -392,35 +493,49 @@ func ruleString(m map[string]interface{}, key, def string) (string, bool) {
 	return def, false
 }
 
-func deepCopy(in map[string]interface{}) map[string]interface{} {
-	b, _ := json.Marshal(in)
-	var out map[string]interface{}
-	_ = json.Unmarshal(b, &out)
-	return out
+// deepCopy copies a document without round-tripping it through JSON, so
+// integer and datetime values decoded by non-JSON codecs keep their types.
+func deepCopy(in *Object) *Object {
+	if in == nil {
+		return NewObject()
+	}
+	return copyValue(in).(*Object)
 }
 
 // ---- Reverse generation ----
 
+// GenerateReverse derives the downgrade for m by inverting its steps in reverse order.
+// It only knows the built-in ops; engines also invert ops added with RegisterOp.
 func GenerateReverse(m Migration) (Migration, error) {
-	rev := Migration{From: m.To, To: m.From, Name: m.Name + "_reverse"}
+	builtin := builtinConditions()
+	return generateReverse(m, func(s MigrationStep) (MigrationStep, bool) { return invertStep(s, builtin) })
+}
+
+func generateReverse(m Migration, invertStep func(MigrationStep) (MigrationStep, bool)) (Migration, error) {
+	rev := Migration{From: m.To, To: m.From, Name: m.Name + "_reverse", VersionField: m.VersionField, Generated: true}
 	for i := len(m.Steps) - 1; i >= 0; i-- {
 		s := m.Steps[i]
 		if s.Reversible != nil && *s.Reversible == false {
+			rev.Dropped = append([]DroppedStep{{Index: i, Step: s, Reason: "marked reversible:false", At: len(rev.Steps)}}, rev.Dropped...)
 			continue
 		}
 		rs, ok := invertStep(s)
 		if !ok {
+			rev.Dropped = append([]DroppedStep{{Index: i, Step: s, Reason: "no inverse for " + s.Op, At: len(rev.Steps)}}, rev.Dropped...)
 			continue
 		}
 		rev.Steps = append(rev.Steps, rs)
 	}
+	rev.Lossy = len(rev.Dropped) > 0
 	if len(rev.Steps) == 0 {
 		return rev, fmt.Errorf("no reversible steps in %s->%s", m.From, m.To)
 	}
 	return rev, nil
 }
 
-func invertStep(s MigrationStep) (MigrationStep, bool) {
+// invertStep inverts a built-in op. Rule conditions are inverted with the
+// inverters in conditions.
+func invertStep(s MigrationStep, conditions map[string]condition) (MigrationStep, bool) {
 	switch s.Op {
 	case "move":
 		return MigrationStep{Op: "move", From: s.To, To: s.From}, true
@@ -457,34 +572,15 @@ func invertStep(s MigrationStep) (MigrationStep, bool) {
 				r["value"] = true
 			}
 		} else if conds, ok := s.Rule["conditions"].([]interface{}); ok {
-			invConds := []interface{}{}
-			for _, c := range conds {
-				cMap, ok := c.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				if ifCond, ok := cMap["if"].(map[string]interface{}); ok {
-					thenVal := cMap["then"]
-					// find a value in ifCond to invert
-					if eqVal, ok := ifCond["equals"]; ok {
-						invConds = append(invConds, map[string]interface{}{
-							"if":   map[string]interface{}{"equals": thenVal},
-							"then": eqVal,
-						})
-					} else {
-						return MigrationStep{}, false
-					}
-				}
+			if _, ok := s.Rule["else"]; ok {
+				// else overwrote items no condition matched; their old values are unknown
+				return MigrationStep{}, false
 			}
-			if len(invConds) > 0 {
-				r["conditions"] = invConds
-				// also invert "else" if present
-				if elseVal, ok := s.Rule["else"]; ok {
-					r["else"] = elseVal
-				}
-			} else {
+			invConds, ok := invertConditions(conds, conditions)
+			if !ok {
 				return MigrationStep{}, false
 			}
+			r["conditions"] = invConds
 		} else {
 			return MigrationStep{}, false
 		}
@@ -518,41 +614,25 @@ func invertStep(s MigrationStep) (MigrationStep, bool) {
 
 	case "set":
 		r := map[string]interface{}{}
+		if _, ok := s.Rule["expr"]; ok {
+			// the values an expression read may be gone or changed by now
+			return MigrationStep{}, false
+		}
 
 		// handle conditional rules
 		if conds, ok := s.Rule["conditions"].([]interface{}); ok {
-			invConds := []interface{}{}
-			for _, c := range conds {
-				cMap, ok := c.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				if ifCond, ok := cMap["if"].(map[string]interface{}); ok {
-					thenVal := cMap["then"]
-					// invert equals ↔ then for downgrade
-					if eqVal, ok := ifCond["equals"]; ok {
-						invConds = append(invConds, map[string]interface{}{
-							"if":   map[string]interface{}{"equals": thenVal},
-							"then": eqVal,
-						})
-					} else {
-						return MigrationStep{}, false
-					}
-				}
-			}
-			if len(invConds) > 0 {
-				r["conditions"] = invConds
-			} else {
+			if _, ok := s.Rule["else"]; ok {
+				// else overwrote a value no condition matched; the old value is unknown
 				return MigrationStep{}, false
 			}
-			// also copy else if present
-			if elseVal, ok := s.Rule["else"]; ok {
-				r["else"] = elseVal
+			// each condition's inverter maps then back to the value it replaced
+			invConds, ok := invertConditions(conds, conditions)
+			if !ok {
+				return MigrationStep{}, false
 			}
-		} else if v, ok := s.Rule["value"]; ok {
-			// simple value copy
-			r["value"] = v
+			r["conditions"] = invConds
 		} else {
+			// a plain "value" overwrites whatever was there; the old value is unknown
 			return MigrationStep{}, false
 		}
 
@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// The CLI writes documents and patches to stdout, so the engine must not.
+func TestEngineKeepsStdoutClean(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	e := NewEngine()
+	loadErr := e.LoadAll("../../migrations")
+	var migrateErr error
+	if loadErr == nil {
+		doc := readTestdataJSON(t, "../../examples/v1_config.json")
+		_, migrateErr = e.Migrate(doc, "v1", "v2")
+	}
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	if migrateErr != nil {
+		t.Fatal(migrateErr)
+	}
+	if len(out) > 0 {
+		t.Errorf("engine wrote to stdout:\n%s", out)
+	}
+}
+
+func readTestdataJSON(t *testing.T, path string) *Object {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := JSON.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
@@ -0,0 +1,100 @@
+package migrate
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// valuesEqual compares document values structurally. Objects compare equal
+// regardless of key order, and *Object and plain maps are interchangeable, so
+// values from migration rules can be compared with document values. Numbers
+// compare by value whatever their Go type, so json.Number("8080"), int64(8080)
+// and float64(8080) are all equal. Datetimes compare as the text they are
+// written as, so a YAML or TOML date equals the string "2024-01-02".
+func valuesEqual(a, b interface{}) bool {
+	if m, ok := a.(map[string]interface{}); ok {
+		a = sortedObject(m, func(v interface{}) interface{} { return v })
+	}
+	if m, ok := b.(map[string]interface{}); ok {
+		b = sortedObject(m, func(v interface{}) interface{} { return v })
+	}
+	switch x := a.(type) {
+	case *Object:
+		y, ok := b.(*Object)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for _, k := range x.keys {
+			yv, ok := y.vals[k]
+			if !ok || !valuesEqual(x.vals[k], yv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valuesEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if x, ok := numberValue(a); ok {
+		y, ok := numberValue(b)
+		return ok && x.Cmp(y) == 0
+	}
+	if x, ok := stringValue(a); ok {
+		y, ok := stringValue(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// stringValue returns a string document value. A datetime decoded by the YAML
+// or TOML codec is taken as the text formatTime writes for it.
+func stringValue(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case time.Time:
+		return formatTime(x), true
+	}
+	return "", false
+}
+
+// numberValue returns the exact value of a numeric document value. Floats are
+// taken at their shortest decimal form, so float64(0.1) equals json.Number("0.1").
+func numberValue(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(n.String())
+	case *big.Rat:
+		return n, true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(rv.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		bits := 64
+		if rv.Kind() == reflect.Float32 {
+			bits = 32
+		}
+		return new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	return nil, false
+}
@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoadError reports a migration or schema file that could not be read, parsed or registered.
+type LoadError struct {
+	File string
+	Err  error
+}
+
+func (e *LoadError) Error() string { return e.File + ": " + e.Err.Error() }
+func (e *LoadError) Unwrap() error { return e.Err }
+
+// NoPathError reports that no chain of migrations connects two versions.
+type NoPathError struct {
+	From string
+	To   string
+}
+
+func (e *NoPathError) Error() string {
+	return fmt.Sprintf("no migration path from %s to %s", e.From, e.To)
+}
+
+// StepError reports a migration step that failed to apply.
+type StepError struct {
+	Migration string // migration name
+	Source    string // file the migration was loaded from, empty for generated ones
+	From      string // hop being applied
+	To        string
+	Step      int // index into Migration.Steps
+	Op        string
+	Path      string // offending path, wildcards expanded where known
+	Err       error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("apply %s->%s: step %d (%s): %v", e.From, e.To, e.Step, e.Op, e.Err)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// ValidationError reports a document that does not conform to the schema of a version.
+type ValidationError struct {
+	Version string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("schema validation failed for version %s: %v", e.Version, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// pathError pins an op failure to the concrete path it happened at.
+type pathError struct {
+	path string
+	err  error
+}
+
+func (e *pathError) Error() string { return e.err.Error() }
+func (e *pathError) Unwrap() error { return e.err }
+
+// stepPath is the path a step primarily operates on, used when an op
+// does not report a more specific one.
+func stepPath(s MigrationStep) string {
+	if s.Op == "move" {
+		return s.From
+	}
+	return s.Path
+}
+
+// UnknownVersionError reports a document whose version could not be determined.
+type UnknownVersionError struct {
+	Reason string
+}
+
+func (e *UnknownVersionError) Error() string {
+	return "cannot detect version: " + e.Reason
+}
+
+// AmbiguousVersionError reports a document that matches more than one version's schema.
+type AmbiguousVersionError struct {
+	Candidates []string
+}
+
+func (e *AmbiguousVersionError) Error() string {
+	return fmt.Sprintf("ambiguous version: document matches schemas %s", strings.Join(e.Candidates, ", "))
+}
+
+// VersionMismatchError reports a document whose version field disagrees with the requested source version.
+type VersionMismatchError struct {
+	Field string
+	Want  string
+	Got   string
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("version field %s is %q, expected %q", e.Field, e.Got, e.Want)
+}
+
+// AmbiguousRouteError reports several equally cheap routes between two versions.
+// Pick one explicitly with Via.
+type AmbiguousRouteError struct {
+	From   string
+	To     string
+	Routes [][]string
+}
+
+func (e *AmbiguousRouteError) Error() string {
+	routes := make([]string, len(e.Routes))
+	for i, r := range e.Routes {
+		routes[i] = strings.Join(r, "->")
+	}
+	return fmt.Sprintf("ambiguous migration path from %s to %s: %s", e.From, e.To, strings.Join(routes, " | "))
+}
+
+// LossyError reports a migration that would silently leave data behind: a
+// generated reverse that dropped forward steps. Opt in with AllowLossy.
+type LossyError struct {
+	Migration string
+	From      string
+	To        string
+	Dropped   []DroppedStep
+	Lost      []LostValue // document values at the dropped steps' paths
+}
+
+func (e *LossyError) Error() string {
+	return fmt.Sprintf("migration %s->%s is lossy: %d forward step(s) cannot be undone, %d value(s) would be lost",
+		e.From, e.To, len(e.Dropped), len(e.Lost))
+}
@@ -0,0 +1,777 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Expressions compute the value a set step writes, from rule.expr:
+//
+//	"http://" + host + ":" + listenPort
+//	replicas > 1 ? "cluster" : "single"
+//	join(split(lower($.meta.tags), ","), ";")
+//
+// An identifier names a sibling of the value being set, a.b and a[0] reach
+// into it, and $.a.b starts at the document root; missing values are null.
+// There are string, number, boolean and null literals, + - * / % on numbers,
+// + on strings (numbers and booleans are formatted into them), comparisons,
+// && || ! on booleans, and cond ? a : b. The only functions are lower, upper,
+// split, join, len and default. Expressions cannot loop, assign or reach
+// anything but the document, and numbers are capped in exponent and size, so
+// an expression that stays within its length and depth limits is cheap to run.
+
+const (
+	maxExprLen   = 4096
+	maxExprDepth = 64
+	maxExprExp   = 400     // largest decimal exponent a number may be written with
+	maxExprBits  = 1 << 15 // largest numerator or denominator a number may reach
+)
+
+// exprFuncs lists the built-in functions with the number of arguments each takes.
+var exprFuncs = map[string]int{
+	"lower":   1,
+	"upper":   1,
+	"split":   2,
+	"join":    2,
+	"len":     1,
+	"default": 2,
+}
+
+// exprNode is a parsed expression.
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+type (
+	exprLit struct{ v interface{} } // string, bool, nil or *big.Rat
+	exprRef struct {
+		name string // "" for $, the document root
+	}
+	exprField struct {
+		x    exprNode
+		name string
+	}
+	exprIndex struct{ x, index exprNode }
+	exprUnary struct {
+		op string
+		x  exprNode
+	}
+	exprBinary struct {
+		op   string
+		x, y exprNode
+	}
+	exprCond struct{ cond, then, els exprNode }
+	exprCall struct {
+		fn   string
+		args []exprNode
+	}
+)
+
+// exprEnv is what an expression can see: the document and the path of the
+// value being set, whose parent holds the identifiers' values.
+type exprEnv struct {
+	root *Object
+	at   string
+}
+
+// exprs caches parsed expressions by source; engines are shared between goroutines.
+var exprs sync.Map // source -> exprNode
+
+// parseExpr parses src, or returns the cached parse of it.
+func parseExpr(src string) (exprNode, error) {
+	if n, ok := exprs.Load(src); ok {
+		return n.(exprNode), nil
+	}
+	if len(src) > maxExprLen {
+		return nil, fmt.Errorf("expr: longer than %d bytes", maxExprLen)
+	}
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	n, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	exprs.Store(src, n)
+	return n, nil
+}
+
+// evalExpr evaluates src for the value at path at in root. Numbers come out
+// as json.Number, like the rest of the document.
+func evalExpr(src string, root *Object, at string) (interface{}, error) {
+	n, err := parseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	v, err := n.eval(&exprEnv{root: root, at: at})
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	if r, ok := v.(*big.Rat); ok {
+		return json.Number(formatRat(r)), nil
+	}
+	return v, nil
+}
+
+// ---- lexer ----
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind tokKind
+	text string // the literal's value for strings
+	pos  int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// exprOps lists the operators, longest first so that "<=" wins over "<".
+var exprOps = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", "[", "]", ",", ".", "$",
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				j++
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+					j++
+				}
+			}
+			toks = append(toks, exprToken{kind: tokNumber, text: src[i:j], pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("expr: unterminated string at offset %d", i)
+			}
+			lit := src[i : j+1]
+			if c == '\'' {
+				// reuse Go's unquoting for single-quoted strings too
+				lit = `"` + strings.ReplaceAll(strings.ReplaceAll(lit[1:len(lit)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			s, err := strconv.Unquote(lit)
+			if err != nil {
+				return nil, fmt.Errorf("expr: invalid string at offset %d", i)
+			}
+			toks = append(toks, exprToken{kind: tokString, text: s, pos: i})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("expr: unexpected character %q at offset %d", c, i)
+			}
+			toks = append(toks, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, exprToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// ---- parser ----
+
+// exprParser is a recursive descent parser. From loosest to tightest:
+// ?:, ||, &&, == !=, < <= > >=, + -, * / %, unary ! -, then . [] and calls.
+type exprParser struct {
+	toks  []exprToken
+	pos   int
+	depth int
+}
+
+func (p *exprParser) peek() exprToken { return p.toks[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the operator op if it comes next.
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %s", op, t)
+	}
+	return nil
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...interface{}) error {
+	return fmt.Errorf("expr: %s at offset %d", fmt.Sprintf(format, args...), t.pos)
+}
+
+func (p *exprParser) ternary() (exprNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExprDepth {
+		return nil, p.errorf(p.peek(), "nested deeper than %d", maxExprDepth)
+	}
+	cond, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return exprCond{cond, then, els}, nil
+}
+
+// exprLevels holds the binary operators by precedence, loosest first.
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || !contains(exprLevels[level], t.text) {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if t := p.peek(); t.kind == tokOp && (t.text == "!" || t.text == "-") {
+		p.next()
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxExprDepth {
+			return nil, p.errorf(t, "nested deeper than %d", maxExprDepth)
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op: t.text, x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (exprNode, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, p.errorf(t, "expected a field name after \".\", found %s", t)
+			}
+			x = exprField{x: x, name: t.text}
+		case p.accept("["):
+			index, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = exprIndex{x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		r, err := parseNumber(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "%v", err)
+		}
+		return exprLit{r}, nil
+	case tokString:
+		return exprLit{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return exprLit{true}, nil
+		case "false":
+			return exprLit{false}, nil
+		case "null":
+			return exprLit{nil}, nil
+		}
+		if !p.accept("(") {
+			return exprRef{name: t.text}, nil
+		}
+		arity, ok := exprFuncs[t.text]
+		if !ok {
+			return nil, p.errorf(t, "unknown function %s", t.text)
+		}
+		var args []exprNode
+		for !p.accept(")") {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		if len(args) != arity {
+			return nil, p.errorf(t, "%s takes %d argument(s), got %d", t.text, arity, len(args))
+		}
+		return exprCall{fn: t.text, args: args}, nil
+	case tokOp:
+		switch t.text {
+		case "$":
+			return exprRef{}, nil
+		case "(":
+			x, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+// ---- evaluation ----
+
+func (n exprLit) eval(*exprEnv) (interface{}, error) { return n.v, nil }
+
+func (n exprRef) eval(env *exprEnv) (interface{}, error) {
+	if n.name == "" {
+		return env.root, nil
+	}
+	path, ok := resolveRelative(env.at, "../"+n.name)
+	if !ok {
+		return nil, nil
+	}
+	v, _, err := getAtPath(env.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return exprValue(v)
+}
+
+func (n exprField) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return member(x, n.name)
+}
+
+func (n exprIndex) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch i := index.(type) {
+	case string:
+		return member(x, i)
+	case *big.Rat:
+		arr, ok := x.([]interface{})
+		if !ok || !i.IsInt() || !i.Num().IsInt64() {
+			return nil, nil
+		}
+		if k := i.Num().Int64(); k >= 0 && k < int64(len(arr)) {
+			return exprValue(arr[k])
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index with %s", jsonType(index))
+}
+
+// member returns field name of an object, or null.
+func member(x interface{}, name string) (interface{}, error) {
+	if obj, ok := x.(*Object); ok {
+		if v, ok := obj.Get(name); ok {
+			return exprValue(v)
+		}
+	}
+	return nil, nil
+}
+
+// exprValue turns the document's numbers into *big.Rat for arithmetic.
+func exprValue(v interface{}) (interface{}, error) {
+	if n, ok := v.(json.Number); ok {
+		return parseNumber(n.String())
+	}
+	if r, ok := numberValue(v); ok {
+		return checkNumber(r)
+	}
+	return v, nil
+}
+
+// parseNumber parses a decimal number. The exponent is checked before the
+// value is expanded, so a short 1e999999 cannot allocate a huge integer.
+func parseNumber(s string) (*big.Rat, error) {
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if errors.Is(err, strconv.ErrRange) || err == nil && (exp > maxExprExp || exp < -maxExprExp) {
+			return nil, fmt.Errorf("number %q out of range: exponent beyond ±%d", s, maxExprExp)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return checkNumber(r)
+}
+
+// checkNumber rejects numbers whose numerator or denominator outgrew
+// maxExprBits, which repeated products would otherwise do without bound.
+func checkNumber(r *big.Rat) (*big.Rat, error) {
+	if r.Num().BitLen() > maxExprBits || r.Denom().BitLen() > maxExprBits {
+		return nil, fmt.Errorf("number too large: more than %d bits", maxExprBits)
+	}
+	return r, nil
+}
+
+func (n exprUnary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! needs a boolean, got %s", jsonType(x))
+		}
+		return !b, nil
+	}
+	r, ok := x.(*big.Rat)
+	if !ok {
+		return nil, fmt.Errorf("- needs a number, got %s", jsonType(x))
+	}
+	return new(big.Rat).Neg(r), nil
+}
+
+func (n exprBinary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// && and || only evaluate their right side when it decides the result
+	if n.op == "&&" || n.op == "||" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, got %s", n.op, jsonType(x))
+		}
+		if b == (n.op == "||") {
+			return b, nil
+		}
+		y, err := n.y.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok = y.(bool); !ok {
+			return nil, fmt.Errorf("%s needs booleans, got %s", n.op, jsonType(y))
+		}
+		return b, nil
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(x, y), nil
+	case "!=":
+		return !valuesEqual(x, y), nil
+	case "<", "<=", ">", ">=":
+		c, ok := compareValues(x, y)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare %s %s %s", jsonType(x), n.op, jsonType(y))
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+
+	_, xs := stringValue(x)
+	_, ys := stringValue(y)
+	if n.op == "+" && (xs || ys) {
+		a, err := exprString(x)
+		if err != nil {
+			return nil, err
+		}
+		b, err := exprString(y)
+		if err != nil {
+			return nil, err
+		}
+		return a + b, nil
+	}
+	a, ok := x.(*big.Rat)
+	b, ok2 := y.(*big.Rat)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, jsonType(x), jsonType(y))
+	}
+	switch n.op {
+	case "+":
+		return checkNumber(new(big.Rat).Add(a, b))
+	case "-":
+		return checkNumber(new(big.Rat).Sub(a, b))
+	case "*":
+		return checkNumber(new(big.Rat).Mul(a, b))
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return checkNumber(new(big.Rat).Quo(a, b))
+	}
+	// %
+	if !a.IsInt() || !b.IsInt() {
+		return nil, fmt.Errorf("%% needs integers")
+	}
+	if b.Sign() == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return new(big.Rat).SetInt(new(big.Int).Rem(a.Num(), b.Num())), nil
+}
+
+func (n exprCond) eval(env *exprEnv) (interface{}, error) {
+	c, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("?: needs a boolean condition, got %s", jsonType(c))
+	}
+	if b {
+		return n.then.eval(env)
+	}
+	return n.els.eval(env)
+}
+
+func (n exprCall) eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	str := func(i int) (string, error) {
+		s, ok := stringValue(args[i])
+		if !ok {
+			return "", fmt.Errorf("%s: argument %d must be a string, got %s", n.fn, i+1, jsonType(args[i]))
+		}
+		return s, nil
+	}
+
+	switch n.fn {
+	case "default":
+		if args[0] == nil {
+			return args[1], nil
+		}
+		return args[0], nil
+	case "len":
+		switch x := args[0].(type) {
+		case string:
+			return new(big.Rat).SetInt64(int64(len([]rune(x)))), nil
+		case []interface{}:
+			return new(big.Rat).SetInt64(int64(len(x))), nil
+		case *Object:
+			return new(big.Rat).SetInt64(int64(len(x.keys))), nil
+		}
+		return nil, fmt.Errorf("len: needs a string, array or object, got %s", jsonType(args[0]))
+	case "join":
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("join: argument 1 must be an array, got %s", jsonType(args[0]))
+		}
+		sep, err := str(1)
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, len(arr))
+		for i, v := range arr {
+			if v, err = exprValue(v); err != nil {
+				return nil, fmt.Errorf("join: %w", err)
+			}
+			if parts[i], err = exprString(v); err != nil {
+				return nil, fmt.Errorf("join: %w", err)
+			}
+		}
+		return strings.Join(parts, sep), nil
+	}
+
+	s, err := str(0)
+	if err != nil {
+		return nil, err
+	}
+	switch n.fn {
+	case "lower":
+		return strings.ToLower(s), nil
+	case "upper":
+		return strings.ToUpper(s), nil
+	}
+	// split
+	sep, err := str(1)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s, sep)
+	out := make([]interface{}, len(parts))
+	for i, p := range parts {
+		out[i] = p
+	}
+	return out, nil
+}
+
+// exprString formats a scalar for string concatenation.
+func exprString(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case *big.Rat:
+		return formatRat(x), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return formatTime(x), nil
+	}
+	return "", fmt.Errorf("cannot use %s as a string", jsonType(v))
+}
+
+// formatRat writes r exactly when it has a finite decimal form, i.e. its
+// denominator has no prime factors but 2 and 5, and as the nearest float
+// otherwise (1/3).
+func formatRat(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	den := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, p := range []int64{2, 5} {
+		n := 0
+		q, m, bp := new(big.Int), new(big.Int), big.NewInt(p)
+		for {
+			q.QuoRem(den, bp, m)
+			if m.Sign() != 0 {
+				break
+			}
+			den.Set(q)
+			n++
+		}
+		digits = max(digits, n)
+	}
+	if den.Cmp(big.NewInt(1)) == 0 {
+		return r.FloatString(digits)
+	}
+	f, _ := r.Float64()
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,197 @@
+package migrate
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const exprDoc = `{
+  "meta": {"tags": "A,B", "list": [1, 2], "env": "prod"},
+  "server": {"host": "h", "listenPort": 8080, "replicas": 3, "price": 12345678901234567.25, "off": null, "huge": 1e999999, "huges": [1e999999]}
+}`
+
+// TestEvalExpr evaluates expressions for server/out in exprDoc and compares
+// the JSON of the result, or the error it fails with.
+func TestEvalExpr(t *testing.T) {
+	tests := []struct {
+		name, expr string
+		want       string // JSON of the result
+		wantErr    string
+	}{
+		// references
+		{"sibling", `host`, `"h"`, ""},
+		{"absolute", `$.meta.env`, `"prod"`, ""},
+		{"index", `$.meta.list[1]`, `2`, ""},
+		{"index by string", `$["meta"].env`, `"prod"`, ""},
+		{"index out of range", `$.meta.list[5]`, `null`, ""},
+		{"missing", `nope`, `null`, ""},
+		{"null field", `off`, `null`, ""},
+
+		// precedence and associativity
+		{"mul before add", `1 + 2 * 3`, `7`, ""},
+		{"parens", `(1 + 2) * 3`, `9`, ""},
+		{"left assoc sub", `10 - 4 - 3`, `3`, ""},
+		{"left assoc div", `8 / 4 / 2`, `1`, ""},
+		{"unary minus", `-2 * -3`, `6`, ""},
+		{"double minus", `1 - -1`, `2`, ""},
+		{"mod", `7 % 4`, `3`, ""},
+		{"compare before and", `1 < 2 && 3 > 2`, `true`, ""},
+		{"and before or", `true || false && false`, `true`, ""},
+		{"not", `!(1 == 2)`, `true`, ""},
+		{"equality before and", `1 == 1 && 2 != 3`, `true`, ""},
+		{"ternary is loosest", `replicas > 1 ? "cluster" : "single"`, `"cluster"`, ""},
+		{"nested ternary", `replicas == 1 ? "one" : replicas == 3 ? "three" : "many"`, `"three"`, ""},
+		{"concat left to right", `1 + 2 + "x"`, `"3x"`, ""},
+		{"concat string first", `"x" + 1 + 2`, `"x12"`, ""},
+
+		// numbers
+		{"concat url", `"http://" + host + ":" + listenPort`, `"http://h:8080"`, ""},
+		{"exact decimal", `price + 0`, `12345678901234567.25`, ""},
+		{"decimal division", `10 / 4`, `2.5`, ""},
+		{"non-terminating division", `1 / 3`, `0.3333333333333333`, ""},
+		{"exponent literal", `1.5e3`, `1500`, ""},
+		{"decimal in string", `"" + 0.1 * 3`, `"0.3"`, ""},
+		{"number equality by value", `listenPort == 8080.0`, `true`, ""},
+
+		// short-circuiting: the right side would fail if evaluated
+		{"and short-circuits", `false && 1 / 0 == 1`, `false`, ""},
+		{"or short-circuits", `true || nope.x - 1`, `true`, ""},
+		{"ternary skips other branch", `true ? 1 : 1 / 0`, `1`, ""},
+
+		// quoting
+		{"double quotes", `"a\"b"`, `"a\"b"`, ""},
+		{"single quotes", `'it\'s'`, `"it's"`, ""},
+		{"double quote inside single", `'say "hi"'`, `"say \"hi\""`, ""},
+		{"escapes", `"tab\there\n"`, `"tab\there\n"`, ""},
+		{"unicode escape", `"caf\u00e9"`, `"café"`, ""},
+
+		// functions
+		{"lower upper", `lower("AbC") + upper("x")`, `"abcX"`, ""},
+		{"split join", `join(split(lower($.meta.tags), ","), ";")`, `"a;b"`, ""},
+		{"join numbers", `join($.meta.list, "-")`, `"1-2"`, ""},
+		{"len string", `len("héllo")`, `5`, ""},
+		{"len array", `len($.meta.list)`, `2`, ""},
+		{"len object", `len($.meta)`, `3`, ""},
+		{"default missing", `default(nope, "x")`, `"x"`, ""},
+		{"default present", `default(host, "x")`, `"h"`, ""},
+		{"default null", `default(off, 0)`, `0`, ""},
+
+		// syntax errors
+		{"empty", ``, "", "unexpected end of expression at offset 0"},
+		{"dangling operator", `1 +`, "", "unexpected end of expression at offset 3"},
+		{"trailing token", `1 2`, "", `unexpected "2" at offset 2`},
+		{"unclosed paren", `(1 + 2`, "", `expected ")", found end of expression`},
+		{"unterminated string", `"abc`, "", "unterminated string at offset 0"},
+		{"bad character", `1 # 2`, "", `unexpected character '#' at offset 2`},
+		{"bad number", `1.2.3`, "", `invalid number "1.2.3"`},
+		{"unknown function", `exec("rm")`, "", "unknown function exec"},
+		{"arity", `lower("a", "b")`, "", "lower takes 1 argument(s), got 2"},
+		{"ternary without else", `true ? 1`, "", `expected ":"`},
+		{"field after dot", `$.1`, "", `expected a field name after "."`},
+
+		// evaluation errors
+		{"subtract strings", `host - 1`, "", "cannot apply - to string and number"},
+		{"null arithmetic", `nope * 2`, "", "cannot apply * to null and number"},
+		{"concat null", `"x" + nope`, "", "cannot use null as a string"},
+		{"division by zero", `1 / 0`, "", "division by zero"},
+		{"fraction mod", `1.5 % 1`, "", "% needs integers"},
+		{"strict and", `1 && true`, "", "&& needs booleans, got number"},
+		{"strict not", `!host`, "", "! needs a boolean, got string"},
+		{"strict ternary", `host ? 1 : 2`, "", "?: needs a boolean condition, got string"},
+		{"compare mixed", `host < 1`, "", "cannot compare string < number"},
+		{"lower non-string", `lower(1)`, "", "lower: argument 1 must be a string, got number"},
+		{"len number", `len(1)`, "", "len: needs a string, array or object, got number"},
+		{"index with bool", `$.meta.list[true]`, "", "cannot index with boolean"},
+
+		// cost limits
+		{"exponent at limit", `1e400 / 1e400`, `1`, ""},
+		{"literal exponent over limit", `1e999999`, "", `number "1e999999" out of range`},
+		{"negative exponent over limit", `1e-401`, "", "out of range"},
+		{"exponent overflowing int", `1e99999999999999999999`, "", "out of range"},
+		{"document exponent over limit", `huge + 1`, "", `number "1e999999" out of range`},
+		{"document exponent in join", `join(huges, ",")`, "", "out of range"},
+		{"product over limit", strings.Repeat("1e400 * ", 30) + "1", "", "number too large"},
+		{"denominator over limit", strings.Repeat("1e-400 * ", 30) + "1", "", "number too large"},
+	}
+
+	doc, err := JSON.Decode([]byte(exprDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := evalExpr(tt.expr, doc, "server/out")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("evalExpr(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("evalExpr(%q): %v", tt.expr, err)
+			}
+			got, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("evalExpr(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExprLimits(t *testing.T) {
+	deep := func(n int) string { return strings.Repeat("(", n) + "1" + strings.Repeat(")", n) }
+	nots := func(n int) string { return strings.Repeat("!", n) + "true" }
+	tests := []struct {
+		name, expr string
+		wantErr    string
+	}{
+		{"nesting at limit", deep(maxExprDepth - 1), ""},
+		{"nesting over limit", deep(maxExprDepth), "nested deeper than"},
+		{"unary at limit", nots(maxExprDepth - 1), ""},
+		{"unary over limit", nots(maxExprDepth + 1), "nested deeper than"},
+		{"nested calls over limit", strings.Repeat("lower(", maxExprDepth) + `"a"` + strings.Repeat(")", maxExprDepth), "nested deeper than"},
+		{"length at limit", `"` + strings.Repeat("a", maxExprLen-2) + `"`, ""},
+		{"length over limit", `"` + strings.Repeat("a", maxExprLen-1) + `"`, "longer than"},
+		// long flat chains do not recurse per operator
+		{"long chain", strings.Repeat("1 + ", 1000) + "1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseExpr(tt.expr)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("parseExpr: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("parseExpr error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatRat(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"3", "3"},
+		{"-7", "-7"},
+		{"0.5", "0.5"},
+		{"-0.125", "-0.125"},
+		{"12345678901234567.25", "12345678901234567.25"},
+		{"0.0000001", "0.0000001"},
+		{"123456789012345678901234567890.2", "123456789012345678901234567890.2"},
+		{"1/3", "0.3333333333333333"},
+		{"2/7", "0.2857142857142857"},
+	}
+	for _, tt := range tests {
+		r, ok := new(big.Rat).SetString(tt.in)
+		if !ok {
+			t.Fatalf("bad rational %s", tt.in)
+		}
+		if got := formatRat(r); got != tt.want {
+			t.Errorf("formatRat(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
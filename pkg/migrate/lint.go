@@ -0,0 +1,483 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Lint severities.
+const (
+	LintError   = "error"   // the migration fails to load or a step fails or misbehaves when applied
+	LintWarning = "warning" // the migration runs, but something in it is ignored or cannot be reversed
+)
+
+// LintIssue is a problem found in a migration file.
+type LintIssue struct {
+	File     string
+	Step     int // index into the migration's steps; -1 for the migration as a whole
+	Op       string
+	Severity string
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	if i.Step < 0 {
+		return fmt.Sprintf("%s: %s: %s", i.File, i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%s: step %d (%s): %s: %s", i.File, i.Step, i.Op, i.Severity, i.Message)
+}
+
+// opSpec describes what a built-in op reads from its step.
+type opSpec struct {
+	required  []string // step fields the op needs; it ignores the others
+	wildcards bool     // whether paths may contain "*"
+	ruleKeys  []string // rule keys the op reads
+}
+
+var opSpecs = map[string]opSpec{
+	"move":         {required: []string{"from", "to"}},
+	"wrap":         {required: []string{"path", "wrapAs"}},
+	"unwrap":       {required: []string{"path", "unwrapTo"}},
+	"mapArray":     {required: []string{"path", "rule"}, wildcards: true, ruleKeys: []string{"stringToObject", "objectToString", "separator", "suffix", "value", "conditions", "else"}},
+	"set":          {required: []string{"path", "rule"}, ruleKeys: []string{"value", "conditions", "else", "expr"}},
+	"original_set": {required: []string{"path", "rule"}, ruleKeys: []string{"value"}},
+	"delete":       {required: []string{"path"}},
+}
+
+// stepFields are the step fields an op may use, in MigrationStep order.
+var stepFields = []string{"from", "to", "path", "wrapAs", "unwrapTo", "rule"}
+
+// Lint checks every migration LoadAll would load from dir, without loading
+// it into the engine. Unknown and misspelt fields are reported rather than
+// ignored, and each step is checked for the fields its op needs, wildcards its
+// op rejects, rule and condition shapes, and whether the generated reverse can
+// undo it. The error is only for files that cannot be read; problems with
+// their content are issues.
+func (e *Engine) Lint(dir string) ([]LintIssue, error) {
+	var issues []LintIssue
+	var parsed []Migration
+	err := walkJSONFiles(os.DirFS(dir), ".", dir, func(path string, b []byte) error {
+		m, found := e.lintFile(path, b)
+		issues = append(issues, found...)
+		if m != nil {
+			parsed = append(parsed, *m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the generated reverse only matters when no hand-written one exists
+	seen := map[string]string{}
+	for _, m := range parsed {
+		if m.From == "" || m.To == "" {
+			continue
+		}
+		key := m.From + "->" + m.To
+		if first, dup := seen[key]; dup {
+			issues = append(issues, LintIssue{File: m.Source, Step: -1, Severity: LintError, Message: fmt.Sprintf("%s is also defined in %s", key, first)})
+			continue
+		}
+		seen[key] = m.Source
+	}
+	broken := map[string]bool{} // file and step of every error so far
+	for _, is := range issues {
+		if is.Severity == LintError {
+			broken[fmt.Sprint(is.File, is.Step)] = true
+		}
+	}
+	for _, m := range parsed {
+		if _, ok := seen[m.To+"->"+m.From]; ok || isRange(m.From) {
+			continue
+		}
+		for i, s := range m.Steps {
+			if (s.Reversible != nil && !*s.Reversible) || broken[fmt.Sprint(m.Source, i)] {
+				continue
+			}
+			if _, ok := e.invertStep(s); !ok {
+				issues = append(issues, LintIssue{
+					File: m.Source, Step: i, Op: s.Op, Severity: LintWarning,
+					Message: fmt.Sprintf("the generated %s->%s reverse cannot undo this step%s; write that migration or mark the step reversible:false", m.To, m.From, irreversibleReason(s)),
+				})
+			}
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Step < issues[j].Step
+	})
+	return issues, nil
+}
+
+// lintFile parses one migration file strictly and checks it on its own. The
+// migration is nil when the file could not be parsed.
+func (e *Engine) lintFile(path string, data []byte) (*Migration, []LintIssue) {
+	var issues []LintIssue
+	report := func(step int, op, severity, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{File: path, Step: step, Op: op, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// decode steps separately so a bad one is reported with its index
+	var raw struct {
+		Migration
+		Steps []json.RawMessage `json:"steps"`
+	}
+	if err := decodeStrict(data, &raw); err != nil {
+		report(-1, "", LintError, "%s", jsonErrorMessage(data, err))
+		return nil, issues
+	}
+	for _, msg := range unknownFields(data, reflect.TypeOf(Migration{})) {
+		report(-1, "", LintError, "%s", msg)
+	}
+	m := raw.Migration
+	m.Source = path
+	m.Steps = make([]MigrationStep, len(raw.Steps))
+
+	switch {
+	case m.From == "" || m.To == "":
+		report(-1, "", LintError, "migration missing from/to")
+	case isRange(m.To):
+		report(-1, "", LintError, "migration target %q must be an exact version", m.To)
+	case isRange(m.From):
+		inside, err := matchRange(e.scheme, m.From, m.To)
+		if err != nil {
+			report(-1, "", LintError, "range %q: %v (ranges need an ordered --scheme)", m.From, err)
+		} else if inside {
+			report(-1, "", LintWarning, "target %s is inside the source range %q", m.To, m.From)
+		}
+	}
+	if len(raw.Steps) == 0 {
+		report(-1, "", LintWarning, "migration has no steps")
+	}
+
+	for i, rs := range raw.Steps {
+		var s MigrationStep
+		if err := decodeStrict(rs, &s); err != nil {
+			report(i, "", LintError, "%s", strings.TrimPrefix(err.Error(), "json: "))
+			continue
+		}
+		m.Steps[i] = s
+		for _, msg := range unknownFields(rs, reflect.TypeOf(s)) {
+			report(i, s.Op, LintError, "%s", msg)
+		}
+		spec, ok := opSpecs[s.Op]
+		if _, custom := e.ops[s.Op]; custom {
+			continue // registered ops declare no fields to check
+		}
+		if !ok {
+			if s.Op == "" {
+				report(i, s.Op, LintError, "step has no op")
+			} else {
+				report(i, s.Op, LintError, "unsupported op %q", s.Op)
+			}
+			continue
+		}
+		for _, f := range spec.required {
+			if !hasStepField(s, f) {
+				report(i, s.Op, LintError, "%s needs %s", s.Op, f)
+			}
+		}
+		for _, f := range stepFields {
+			if hasStepField(s, f) && !contains(spec.required, f) {
+				report(i, s.Op, LintWarning, "%s is ignored by %s", f, s.Op)
+			}
+		}
+		if !spec.wildcards {
+			for _, f := range []string{"from", "to", "path", "unwrapTo"} {
+				if hasWildcard(stepField(s, f)) {
+					report(i, s.Op, LintError, "%s does not support wildcards: %s=%s", s.Op, f, stepField(s, f))
+				}
+			}
+		}
+		if s.Rule != nil && contains(spec.required, "rule") {
+			for _, msg := range e.lintRule(s.Op, s.Rule, spec.ruleKeys) {
+				report(i, s.Op, msg[0], "%s", msg[1])
+			}
+		}
+	}
+	return &m, issues
+}
+
+// lintRule checks a step's rule, returning (severity, message) pairs.
+func (e *Engine) lintRule(op string, rule map[string]interface{}, keys []string) [][2]string {
+	var out [][2]string
+	add := func(severity, format string, args ...interface{}) {
+		out = append(out, [2]string{severity, fmt.Sprintf(format, args...)})
+	}
+	ruleKeys := make([]string, 0, len(rule))
+	for k := range rule {
+		ruleKeys = append(ruleKeys, k)
+	}
+	sort.Strings(ruleKeys)
+	for _, k := range ruleKeys {
+		if !contains(keys, k) {
+			add(LintWarning, "rule key %q is ignored by %s", k, op)
+		}
+	}
+
+	_, hasValue := rule["value"]
+	_, hasConds := rule["conditions"]
+	_, hasElse := rule["else"]
+	_, hasExpr := rule["expr"]
+	switch op {
+	case "set":
+		if !hasValue && !hasConds && !hasExpr {
+			add(LintError, "rule needs value, conditions or expr")
+		}
+		if hasExpr {
+			if src, ok := rule["expr"].(string); !ok {
+				add(LintError, "rule expr must be a string")
+			} else if _, err := parseExpr(src); err != nil {
+				add(LintError, "rule %v", err)
+			}
+			if hasValue || hasConds || hasElse {
+				add(LintWarning, "rule value, conditions and else are ignored when expr is given")
+			}
+		}
+		if hasValue && hasConds && !hasExpr {
+			add(LintWarning, "rule value is ignored when conditions are given")
+		}
+		if hasElse && !hasConds {
+			add(LintWarning, "rule else is ignored without conditions")
+		}
+	case "original_set":
+		if !hasValue {
+			add(LintError, "rule needs value")
+		}
+	case "mapArray":
+		s2o, _ := rule["stringToObject"].(bool)
+		o2s, _ := rule["objectToString"].(bool)
+		if !s2o && !o2s && !hasConds {
+			add(LintWarning, "rule does nothing: it needs stringToObject, objectToString or conditions")
+		}
+	}
+	if hasConds {
+		conds, ok := rule["conditions"].([]interface{})
+		if !ok {
+			add(LintError, "rule conditions must be an array")
+			return out
+		}
+		for j, c := range conds {
+			cm, ok := c.(map[string]interface{})
+			if !ok {
+				add(LintError, "condition %d must be an object with if and then", j)
+				continue
+			}
+			pred, ok := cm["if"].(map[string]interface{})
+			if !ok {
+				add(LintError, "condition %d needs an if object", j)
+			}
+			if _, ok := cm["then"]; !ok {
+				add(LintError, "condition %d needs then", j)
+			}
+			if pred != nil {
+				for _, p := range e.predicateProblems(pred) {
+					add(LintError, "condition %d: %s", j, p)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// predicateProblems checks a predicate object and the predicates nested in
+// its combinators: every name must be known and every argument well formed.
+func (e *Engine) predicateProblems(pred map[string]interface{}) []string {
+	if len(pred) == 0 {
+		return []string{"empty predicate never matches"}
+	}
+	var out []string
+	for _, name := range conditionNames(pred) {
+		arg := pred[name]
+		switch name {
+		case condPath:
+			p, ok := arg.(string)
+			switch {
+			case !ok || p == "":
+				out = append(out, "path must be a non-empty string")
+			case hasWildcard(p):
+				out = append(out, fmt.Sprintf("path %q: wildcards not allowed", p))
+			case len(pred) == 1:
+				out = append(out, "path alone tests nothing; add a predicate next to it")
+			}
+		case condAllOf, condAnyOf:
+			list, ok := arg.([]interface{})
+			if !ok || len(list) == 0 {
+				out = append(out, fmt.Sprintf("%s needs a non-empty array of predicates", name))
+				continue
+			}
+			for i, item := range list {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					out = append(out, fmt.Sprintf("%s[%d] must be a predicate object", name, i))
+					continue
+				}
+				for _, p := range e.predicateProblems(sub) {
+					out = append(out, fmt.Sprintf("%s[%d]: %s", name, i, p))
+				}
+			}
+		case condNot:
+			sub, ok := arg.(map[string]interface{})
+			if !ok {
+				out = append(out, "not needs a predicate object")
+				continue
+			}
+			for _, p := range e.predicateProblems(sub) {
+				out = append(out, "not: "+p)
+			}
+		default:
+			c, ok := e.conditions[name]
+			if !ok {
+				out = append(out, fmt.Sprintf("unknown predicate %q", name))
+				continue
+			}
+			if c.check != nil {
+				if err := c.check(arg); err != nil {
+					out = append(out, fmt.Sprintf("predicate %q %v", name, err))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// irreversibleReason explains why invertStep rejects s, when the reason is
+// more specific than the op.
+func irreversibleReason(s MigrationStep) string {
+	switch s.Op {
+	case "set":
+		if _, ok := s.Rule["expr"]; ok {
+			return " (an expression has no inverse)"
+		}
+		if _, ok := s.Rule["else"]; ok {
+			return " (else overwrites values no condition matched)"
+		}
+		if _, ok := s.Rule["conditions"]; ok {
+			return " (a condition has no inverse)"
+		}
+		return " (a plain value overwrites the old one)"
+	case "mapArray":
+		if _, ok := s.Rule["conditions"]; ok {
+			if _, ok := s.Rule["else"]; ok {
+				return " (else overwrites items no condition matched)"
+			}
+			return " (a condition has no inverse)"
+		}
+	case "delete":
+		return " (the deleted value is gone)"
+	}
+	if _, builtin := opSpecs[s.Op]; !builtin {
+		return " (the op has no inverter)"
+	}
+	return ""
+}
+
+// decodeStrict decodes data into v and rejects trailing data. Unknown fields
+// are left to unknownFields.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := newDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after top-level value")
+	}
+	return nil
+}
+
+// unknownFields describes the keys of the JSON object data that are not JSON
+// fields of struct type t. Keys are compared exactly: encoding/json would
+// accept "wrapas" for "wrapAs", but the misspelling is still worth fixing.
+func unknownFields(data []byte, t reflect.Type) []string {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) != nil {
+		return nil
+	}
+	known := map[string]string{} // lower-cased name -> name
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[strings.ToLower(name)] = name
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		switch name, ok := known[strings.ToLower(k)]; {
+		case !ok:
+			out = append(out, fmt.Sprintf("unknown field %q", k))
+		case name != k:
+			out = append(out, fmt.Sprintf("field %q should be spelled %q", k, name))
+		}
+	}
+	return out
+}
+
+// jsonErrorMessage adds the line and column to JSON syntax and type errors.
+func jsonErrorMessage(data []byte, err error) string {
+	var offset int64 = -1
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &se):
+		offset = se.Offset
+	case errors.As(err, &te):
+		offset = te.Offset
+	}
+	msg := strings.TrimPrefix(err.Error(), "json: ")
+	if te != nil && te.Field != "" {
+		msg = fmt.Sprintf("field %q: expected %s, got %s", te.Field, te.Type, te.Value)
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return msg
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	col := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Sprintf("line %d, column %d: %s", line, col, msg)
+}
+
+func hasStepField(s MigrationStep, name string) bool {
+	if name == "rule" {
+		return s.Rule != nil
+	}
+	return stepField(s, name) != ""
+}
+
+func stepField(s MigrationStep, name string) string {
+	switch name {
+	case "from":
+		return s.From
+	case "to":
+		return s.To
+	case "path":
+		return s.Path
+	case "wrapAs":
+		return s.WrapAs
+	case "unwrapTo":
+		return s.UnwrapTo
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package migrate
+
+// LostValue is a document value a lossy hop leaves as is instead of reverting.
+type LostValue struct {
+	Migration string
+	Step      int // index of the dropped step in the forward migration
+	Op        string
+	Path      string
+	Value     interface{}
+}
+
+// lostData collects the values at the paths touched by those of hop's dropped
+// steps that would have been undone before reverse step at. The steps' paths
+// name places in the document as the forward step left it, which is how doc
+// looks again once the reverse steps before at have run.
+func lostData(doc *Object, hop PlanHop, at int) ([]LostValue, error) {
+	var out []LostValue
+	for _, d := range hop.Migration.Dropped {
+		if d.At != at {
+			continue
+		}
+		for _, p := range []string{d.Step.Path, d.Step.To, d.Step.UnwrapTo} {
+			if p == "" {
+				continue
+			}
+			paths, err := expandPath(doc, p)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				v, _, _ := getAtPath(doc, path)
+				out = append(out, LostValue{
+					Migration: hop.Migration.Name, Step: d.Index, Op: d.Step.Op,
+					Path: path, Value: copyValue(v),
+				})
+			}
+		}
+	}
+	return out, nil
+}
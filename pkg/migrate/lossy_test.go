@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A value set by a dropped step and then moved by a later one is reported at
+// the place the dropped step put it, with the value it has there.
+func TestLostDataFollowsLaterSteps(t *testing.T) {
+	e := NewEngine()
+	fwd := Migration{From: "1", To: "2", Steps: []MigrationStep{
+		{Op: "set", Path: "x", Rule: map[string]interface{}{"value": json.Number("5")}},
+		{Op: "move", From: "x", To: "y"},
+	}}
+	if err := e.addMigration(fwd); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := generateReverse(fwd, e.invertStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.addMigration(rev); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := NewObject()
+	doc.Set("y", json.Number("5"))
+	_, err = e.Migrate(doc, "2", "1")
+	var lo *LossyError
+	if !errors.As(err, &lo) {
+		t.Fatalf("Migrate error = %v, want a LossyError", err)
+	}
+	if len(lo.Lost) != 1 || lo.Lost[0].Path != "x" || lo.Lost[0].Value != json.Number("5") {
+		t.Fatalf("Lost = %+v, want x = 5", lo.Lost)
+	}
+
+	res, err := e.Migrate(doc, "2", "1", AllowLossy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Lost) != 1 || res.Lost[0].Path != "x" {
+		t.Fatalf("Lost = %+v, want x", res.Lost)
+	}
+}
+
+// An else value overwrote whatever no condition matched, so a reverse cannot
+// restore it: the step is dropped, the reverse is lossy and lint says why.
+func TestElseIsNotInvertible(t *testing.T) {
+	conds := []interface{}{map[string]interface{}{"if": map[string]interface{}{"equals": "a"}, "then": "b"}}
+	tests := []struct {
+		name string
+		step MigrationStep
+		want string
+	}{
+		{"set", MigrationStep{Op: "set", Path: "mode", Rule: map[string]interface{}{"conditions": conds, "else": "z"}}, "else overwrites values no condition matched"},
+		{"mapArray", MigrationStep{Op: "mapArray", Path: "modes/*", Rule: map[string]interface{}{"conditions": conds, "else": "z"}}, "else overwrites items no condition matched"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fwd := Migration{Name: "1_to_2", From: "1", To: "2", Steps: []MigrationStep{tt.step, {Op: "move", From: "a", To: "b"}}}
+			e := NewEngine()
+			if err := e.addMigration(fwd); err != nil {
+				t.Fatal(err)
+			}
+			rev, err := generateReverse(fwd, e.invertStep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rev.Dropped) != 1 || rev.Dropped[0].Index != 0 {
+				t.Fatalf("reverse Dropped = %+v, want step 0", rev.Dropped)
+			}
+			if err := e.addMigration(rev); err != nil {
+				t.Fatal(err)
+			}
+			plan, err := e.Plan("2", "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan.Hops) != 1 || !plan.Hops[0].Migration.Lossy {
+				t.Fatalf("plan hops = %+v, want one lossy hop", plan.Hops)
+			}
+
+			dir := t.TempDir()
+			data, err := json.Marshal(fwd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "1_to_2.json"), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			issues, err := NewEngine().Lint(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != 1 || issues[0].Severity != LintWarning || !strings.Contains(issues[0].Message, tt.want) {
+				t.Fatalf("Lint = %+v, want one warning containing %q", issues, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Object is a document object that remembers the order of its keys. Documents
+// handled by the engine use *Object for every object, []interface{} for arrays
+// and plain Go values for scalars.
+//
+// Setting an existing key keeps its position, new keys are appended, and Rename
+// puts the new key where the old one was.
+type Object struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+// NewObject returns an empty object.
+func NewObject() *Object {
+	return &Object{vals: make(map[string]interface{})}
+}
+
+// Len returns the number of keys.
+func (o *Object) Len() int { return len(o.keys) }
+
+// Keys returns the keys in order.
+func (o *Object) Keys() []string { return append([]string(nil), o.keys...) }
+
+// Get returns the value stored under key.
+func (o *Object) Get(key string) (interface{}, bool) {
+	v, ok := o.vals[key]
+	return v, ok
+}
+
+// Set stores v under key, appending key if it is new.
+func (o *Object) Set(key string, v interface{}) {
+	if o.vals == nil {
+		o.vals = make(map[string]interface{})
+	}
+	if _, ok := o.vals[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.vals[key] = v
+}
+
+// Delete removes key.
+func (o *Object) Delete(key string) {
+	if _, ok := o.vals[key]; !ok {
+		return
+	}
+	delete(o.vals, key)
+	o.keys = removeKey(o.keys, key)
+}
+
+// Rename moves the value under from to to, keeping from's position. An
+// existing value under to is replaced.
+func (o *Object) Rename(from, to string) {
+	v, ok := o.vals[from]
+	if !ok || from == to {
+		return
+	}
+	if _, exists := o.vals[to]; exists {
+		o.keys = removeKey(o.keys, to)
+	}
+	for i, k := range o.keys {
+		if k == from {
+			o.keys[i] = to
+		}
+	}
+	delete(o.vals, from)
+	o.vals[to] = v
+}
+
+func removeKey(keys []string, key string) []string {
+	for i, k := range keys {
+		if k == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+// MarshalJSON writes the keys in order.
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(jsonValue(o.vals[k]))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON reads an object, keeping key order at every level.
+func (o *Object) UnmarshalJSON(data []byte) error {
+	v, err := decodeOrdered(newDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	obj, ok := v.(*Object)
+	if !ok {
+		return fmt.Errorf("expected a JSON object, got %T", v)
+	}
+	*o = *obj
+	return nil
+}
+
+// newDecoder returns a JSON decoder that keeps numbers as json.Number, so
+// large integer IDs and decimals pass through a migration exactly as written.
+func newDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec
+}
+
+// decodeOrdered reads one JSON value from dec, building *Object for objects.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := NewObject()
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := kt.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected object key, got %v", kt)
+				}
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.Set(key, v)
+			}
+			if _, err := dec.Token(); err != nil { // '}'
+				return nil, err
+			}
+			return obj, nil
+		case '[':
+			arr := []interface{}{}
+			for dec.More() {
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+			if _, err := dec.Token(); err != nil { // ']'
+				return nil, err
+			}
+			return arr, nil
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	default:
+		return tok, nil
+	}
+}
+
+// decodeOrderedDocument reads a single top-level JSON object and rejects trailing data.
+func decodeOrderedDocument(r io.Reader) (*Object, error) {
+	dec := newDecoder(r)
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	obj, ok := v.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("top-level JSON value must be an object, got %T", v)
+	}
+	return obj, nil
+}
+
+// FromPlain converts plain decoded values (map[string]interface{} objects) into
+// a deep copy in the ordered document model. Keys of plain maps have no order,
+// so they are sorted.
+func FromPlain(v interface{}) interface{} {
+	return copyValue(v)
+}
+
+// sortedObject builds an object from a plain map, keys sorted, values converted with conv.
+func sortedObject(m map[string]interface{}, conv func(interface{}) interface{}) *Object {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	obj := &Object{keys: keys, vals: make(map[string]interface{}, len(m))}
+	for _, k := range keys {
+		obj.vals[k] = conv(m[k])
+	}
+	return obj
+}
+
+// ToPlain converts a document back to map[string]interface{} objects, dropping key order.
+func ToPlain(v interface{}) interface{} {
+	switch x := v.(type) {
+	case *Object:
+		out := make(map[string]interface{}, len(x.keys))
+		for _, k := range x.keys {
+			out[k] = ToPlain(x.vals[k])
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = ToPlain(val)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestObjectKeyOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(o *Object)
+		want string // JSON of the object after edit
+	}{
+		{"set existing keeps place", func(o *Object) { o.Set("b", 9) }, `{"a":1,"b":9,"c":3}`},
+		{"set new appends", func(o *Object) { o.Set("d", 4) }, `{"a":1,"b":2,"c":3,"d":4}`},
+		{"delete then set appends", func(o *Object) { o.Delete("a"); o.Set("a", 1) }, `{"b":2,"c":3,"a":1}`},
+		{"rename keeps place", func(o *Object) { o.Rename("b", "x") }, `{"a":1,"x":2,"c":3}`},
+		{"rename first", func(o *Object) { o.Rename("a", "z") }, `{"z":1,"b":2,"c":3}`},
+		{"rename onto later key", func(o *Object) { o.Rename("a", "c") }, `{"c":1,"b":2}`},
+		{"rename onto earlier key", func(o *Object) { o.Rename("c", "a") }, `{"b":2,"a":3}`},
+		{"rename to itself", func(o *Object) { o.Rename("b", "b") }, `{"a":1,"b":2,"c":3}`},
+		{"rename missing", func(o *Object) { o.Rename("nope", "x") }, `{"a":1,"b":2,"c":3}`},
+		{"rename then set", func(o *Object) { o.Rename("a", "x"); o.Set("a", 0) }, `{"x":1,"b":2,"c":3,"a":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewObject()
+			o.Set("a", 1)
+			o.Set("b", 2)
+			o.Set("c", 3)
+			tt.edit(o)
+			got, err := json.Marshal(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if o.Len() != len(o.Keys()) || len(o.Keys()) != len(o.vals) {
+				t.Errorf("keys %v out of step with %d values", o.Keys(), len(o.vals))
+			}
+		})
+	}
+}
+
+func TestObjectUnmarshalKeepsOrder(t *testing.T) {
+	const in = `{"z":1,"a":{"y":2,"b":3},"m":[{"q":4,"c":5}]}`
+	o := NewObject()
+	if err := json.Unmarshal([]byte(in), o); err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != in {
+		t.Errorf("got %s, want %s", got, in)
+	}
+}
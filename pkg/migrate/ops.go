@@ -0,0 +1,89 @@
+package migrate
+
+import "fmt"
+
+// OpFunc applies a custom step op to the document behind ctx.
+type OpFunc func(ctx *OpContext, step MigrationStep) error
+
+// InvertFunc returns the step that undoes step, or false when it cannot be
+// undone and a generated reverse has to drop it.
+type InvertFunc func(step MigrationStep) (MigrationStep, bool)
+
+type customOp struct {
+	apply  OpFunc
+	invert InvertFunc
+}
+
+// RegisterOp adds a step op named name. invert may be nil, in which case
+// generated reverses drop the op's steps and are marked lossy. Register ops
+// before loading migrations that use them, since reverses are generated at
+// load time. The built-in ops cannot be replaced.
+func (e *Engine) RegisterOp(name string, apply OpFunc, invert InvertFunc) error {
+	if name == "" || apply == nil {
+		return fmt.Errorf("register op %q: name and apply function are required", name)
+	}
+	if _, builtin := opSpecs[name]; builtin {
+		return fmt.Errorf("register op %q: cannot replace a built-in op", name)
+	}
+	if _, dup := e.ops[name]; dup {
+		return fmt.Errorf("register op %q: already registered", name)
+	}
+	e.ops[name] = customOp{apply: apply, invert: invert}
+	return nil
+}
+
+// OpContext gives a custom op access to the document being migrated. Values
+// returned by Get are the document's own; change the document only through
+// Set, Delete and Move so every change is recorded for --explain and patches.
+type OpContext struct {
+	cl *changeLog
+}
+
+// Doc returns the document being migrated, for reading.
+func (c *OpContext) Doc() *Object { return c.cl.root }
+
+// Get returns the value at a slash path without wildcards.
+func (c *OpContext) Get(path string) (interface{}, bool, error) {
+	return getAtPath(c.cl.root, path)
+}
+
+// Expand lists the concrete paths a path with "*" wildcards matches.
+func (c *OpContext) Expand(path string) ([]string, error) {
+	return expandPath(c.cl.root, path)
+}
+
+// Set writes a copy of v at path, creating missing parent objects.
+func (c *OpContext) Set(path string, v interface{}) error {
+	return c.cl.set(path, v)
+}
+
+// Delete removes the value at path.
+func (c *OpContext) Delete(path string) error {
+	return c.cl.delete(path)
+}
+
+// Move moves the value at from to to. It fails when nothing is at from.
+func (c *OpContext) Move(from, to string) error {
+	return c.cl.move(from, to)
+}
+
+// applyCustomOp runs a registered op, or reports step.Op as unsupported.
+func (e *Engine) applyCustomOp(cl *changeLog, step MigrationStep) error {
+	op, ok := e.ops[step.Op]
+	if !ok {
+		return fmt.Errorf("unsupported op %q", step.Op)
+	}
+	return op.apply(&OpContext{cl: cl}, step)
+}
+
+// invertStep inverts built-in ops with the engine's conditions and registered
+// ops with their inverter.
+func (e *Engine) invertStep(s MigrationStep) (MigrationStep, bool) {
+	if op, ok := e.ops[s.Op]; ok {
+		if op.invert == nil {
+			return MigrationStep{}, false
+		}
+		return op.invert(s)
+	}
+	return invertStep(s, e.conditions)
+}
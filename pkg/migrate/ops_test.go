@@ -0,0 +1,57 @@
+package migrate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOpContextMoveMissingSource(t *testing.T) {
+	e := NewEngine()
+	err := e.RegisterOp("relocate", func(ctx *OpContext, step MigrationStep) error {
+		return ctx.Move(step.From, step.To)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.addMigration(Migration{From: "1", To: "2", Steps: []MigrationStep{{Op: "relocate", From: "missing", To: "moved/here"}}}); err != nil {
+		t.Fatal(err)
+	}
+	doc := NewObject()
+	doc.Set("kept", true)
+	_, err = e.Migrate(doc, "1", "2")
+	if err == nil || !strings.Contains(err.Error(), "source not found missing") {
+		t.Fatalf("Migrate error = %v, want source not found", err)
+	}
+}
+
+func TestMoveIntoItself(t *testing.T) {
+	for _, tc := range []struct{ from, to string }{
+		{"a", "a"},
+		{"a", "a/b"},
+		{"/a/", "a/b/c"},
+	} {
+		e := NewEngine()
+		if err := e.addMigration(Migration{From: "1", To: "2", Steps: []MigrationStep{{Op: "move", From: tc.from, To: tc.to}}}); err != nil {
+			t.Fatal(err)
+		}
+		doc := NewObject()
+		doc.Set("a", NewObject())
+		_, err := e.Migrate(doc, "1", "2")
+		var se *StepError
+		if !errors.As(err, &se) || !strings.Contains(err.Error(), "into itself") {
+			t.Errorf("move %s -> %s: error = %v, want StepError", tc.from, tc.to, err)
+		}
+		if _, ok, _ := getAtPath(doc, "a"); !ok || len(doc.Keys()) != 1 {
+			t.Errorf("move %s -> %s changed the document", tc.from, tc.to)
+		}
+
+		cl := &changeLog{root: doc}
+		if err := cl.move(tc.from, tc.to); err == nil {
+			t.Errorf("changeLog.move %s -> %s: want error", tc.from, tc.to)
+		}
+		if len(cl.changes) != 0 {
+			t.Errorf("changeLog.move %s -> %s recorded %v", tc.from, tc.to, cl.changes)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PatchOp is a single RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MarshalJSON always emits "value" for add and replace, even when it is null or false.
+func (p PatchOp) MarshalJSON() ([]byte, error) {
+	if p.Op != "add" && p.Op != "replace" {
+		type plain PatchOp
+		return json.Marshal(plain(p))
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{p.Op, p.Path, jsonValue(p.Value)})
+}
+
+// Patch returns a JSON Patch that turns the input document into r.Doc.
+// It replays the change log, so move steps come out as real move operations. A
+// wrap is emitted as a replace: RFC 6902 forbids moving a value into its own child.
+func (r *Result) Patch() []PatchOp {
+	return ChangesToPatch(r.Changes)
+}
+
+// ChangesToPatch converts a change log into the equivalent JSON Patch.
+func ChangesToPatch(changes []Change) []PatchOp {
+	ops := make([]PatchOp, 0, len(changes))
+	for _, c := range changes {
+		op := PatchOp{Op: c.Kind, Path: pointer(c.Path)}
+		switch c.Kind {
+		case ChangeAdd, ChangeReplace:
+			op.Value = c.After
+		case ChangeMove:
+			op.From = pointer(c.From)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// pointer converts a slash path to an RFC 6901 JSON Pointer.
+func pointer(path string) string {
+	var b strings.Builder
+	for _, seg := range split(path) {
+		b.WriteByte('/')
+		seg = strings.ReplaceAll(seg, "~", "~0")
+		b.WriteString(strings.ReplaceAll(seg, "/", "~1"))
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package migrate
+
+import "fmt"
+
+// Plan is the ordered list of migrations Apply runs to get from one version to another.
+type Plan struct {
+	From  string
+	To    string
+	Chain []string // versions visited, including From and To
+	Hops  []PlanHop
+	Cost  int // summed edge cost; lower is preferred
+}
+
+// PlanHop is a single migration on a plan's chain.
+type PlanHop struct {
+	From      string
+	To        string
+	Migration Migration
+}
+
+// Option tunes a single Plan, Migrate or Apply call.
+type Option func(*options)
+
+type options struct {
+	via        []string
+	allowLossy bool
+}
+
+// Via forces the route through the given versions, in order.
+func Via(versions ...string) Option {
+	return func(o *options) { o.via = append(o.via, versions...) }
+}
+
+// AllowLossy lets Migrate run generated reverses that cannot undo every forward
+// step. The values those steps leave behind are reported in Result.Lost.
+func AllowLossy() Option {
+	return func(o *options) { o.allowLossy = true }
+}
+
+func collectOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Plan resolves the chain from->to without touching any document.
+func (e *Engine) Plan(from, to string, opts ...Option) (*Plan, error) {
+	o := collectOptions(opts)
+	if to == VersionLatest {
+		latest, err := e.LatestVersion()
+		if err != nil {
+			return nil, err
+		}
+		to = latest
+	}
+	p := &Plan{From: from, To: to, Chain: []string{from}}
+	if from == to && len(o.via) == 0 {
+		return p, nil
+	}
+	chain, cost, err := e.route(from, to, o.via)
+	if err != nil {
+		return nil, err
+	}
+	p.Chain, p.Cost = chain, cost
+	for i := 0; i < len(chain)-1; i++ {
+		a, b := chain[i], chain[i+1]
+		mig, ok := e.edge(a, b)
+		if !ok {
+			return nil, fmt.Errorf("missing migration %s->%s", a, b)
+		}
+		p.Hops = append(p.Hops, PlanHop{From: a, To: b, Migration: mig})
+	}
+	return p, nil
+}
@@ -36,13 +36,36 @@ func hasWildcard(path string) bool {
 	return strings.Contains(path, "*")
 }
 
+// resolveRelative resolves rel against the slash path base. A rel starting
+// with "/" is absolute; otherwise each ".." segment steps up from base and "."
+// stays put. It returns false when rel climbs above the root.
+func resolveRelative(base, rel string) (string, bool) {
+	var segs []string
+	if !strings.HasPrefix(rel, "/") {
+		segs = split(base)
+	}
+	for _, seg := range split(rel) {
+		switch seg {
+		case ".":
+		case "..":
+			if len(segs) == 0 {
+				return "", false
+			}
+			segs = segs[:len(segs)-1]
+		default:
+			segs = append(segs, seg)
+		}
+	}
+	return strings.Join(segs, "/"), true
+}
+
 // getAtPath returns the value at a non-wildcard path.
-func getAtPath(root map[string]interface{}, path string) (interface{}, bool, error) {
+func getAtPath(root *Object, path string) (interface{}, bool, error) {
 	cur := interface{}(root)
 	for _, seg := range split(path) {
 		switch node := cur.(type) {
-		case map[string]interface{}:
-			nxt, ok := node[seg]
+		case *Object:
+			nxt, ok := node.Get(seg)
 			if !ok {
 				return nil, false, nil
 			}
@@ -65,31 +88,32 @@ func getAtPath(root map[string]interface{}, path string) (interface{}, bool, err
 	return cur, true, nil
 }
 
-// setAtPath sets value at a non-wildcard path, creating missing objects for map segments.
-func setAtPath(root map[string]interface{}, path string, val interface{}) error {
+// setAtPath sets value at a non-wildcard path, creating missing objects for map
+// segments. A new key is appended to its object; an existing one keeps its place.
+func setAtPath(root *Object, path string, val interface{}) error {
 	cur := interface{}(root)
 	segs := split(path)
 	for i, seg := range segs {
 		last := i == len(segs)-1
 		switch node := cur.(type) {
-		case map[string]interface{}:
+		case *Object:
 			if last {
-				node[seg] = val
+				node.Set(seg, val)
 				return nil
 			}
 			// ensure next container exists
 			if _, ok := isIndex(segs[i+1]); ok {
 				// next is an array index; we expect current[seg] to be []interface{}
-				nxt, ok := node[seg]
+				nxt, ok := node.Get(seg)
 				if !ok {
 					return fmt.Errorf("cannot create array automatically for %s", path)
 				}
 				cur = nxt
 			} else {
-				nxt, ok := node[seg]
+				nxt, ok := node.Get(seg)
 				if !ok {
-					nxt = map[string]interface{}{}
-					node[seg] = nxt
+					nxt = NewObject()
+					node.Set(seg, nxt)
 				}
 				cur = nxt
 			}
@@ -115,18 +139,18 @@ func setAtPath(root map[string]interface{}, path string, val interface{}) error
 }
 
 // deleteAtPath removes a map key at a non-wildcard path. Deleting array elements is not supported in this engine.
-func deleteAtPath(root map[string]interface{}, path string) error {
+func deleteAtPath(root *Object, path string) error {
 	cur := interface{}(root)
 	segs := split(path)
 	for i, seg := range segs {
 		last := i == len(segs)-1
 		switch node := cur.(type) {
-		case map[string]interface{}:
+		case *Object:
 			if last {
-				delete(node, seg)
+				node.Delete(seg)
 				return nil
 			}
-			nxt, ok := node[seg]
+			nxt, ok := node.Get(seg)
 			if !ok {
 				return nil
 			}
@@ -150,31 +174,61 @@ func deleteAtPath(root map[string]interface{}, path string) error {
 	return nil
 }
 
+// moveAtPath moves the value at from to to. Within one object the key is
+// renamed in place; otherwise it is appended to the destination object.
+func moveAtPath(root *Object, from, to string) error {
+	fs, ts := split(from), split(to)
+	if len(fs) > 0 && len(ts) > 0 && strings.Join(fs[:len(fs)-1], "/") == strings.Join(ts[:len(ts)-1], "/") {
+		parent, ok, err := getAtPath(root, strings.Join(fs[:len(fs)-1], "/"))
+		if err != nil {
+			return err
+		}
+		if obj, isObj := parent.(*Object); ok && isObj {
+			obj.Rename(fs[len(fs)-1], ts[len(ts)-1])
+			return nil
+		}
+	}
+	v, _, err := getAtPath(root, from)
+	if err != nil {
+		return err
+	}
+	if err := setAtPath(root, to, v); err != nil {
+		return err
+	}
+	return deleteAtPath(root, from)
+}
+
+// arrayMatch is an array found by findArrays together with its concrete path.
+type arrayMatch struct {
+	Path  string
+	Items []interface{}
+}
+
 // findArrays returns all arrays that match a wildcard path (e.g., a/*/b/*/c)
-func findArrays(root map[string]interface{}, path string) ([][]interface{}, error) {
+func findArrays(root *Object, path string) ([]arrayMatch, error) {
 	segs := split(path)
-	var out [][]interface{}
-	var walk func(cur interface{}, i int) error
-	walk = func(cur interface{}, i int) error {
+	var out []arrayMatch
+	var walk func(cur interface{}, i int, at []string) error
+	walk = func(cur interface{}, i int, at []string) error {
 		if i == len(segs) {
 			if arr, ok := cur.([]interface{}); ok {
-				out = append(out, arr)
+				out = append(out, arrayMatch{Path: strings.Join(at, "/"), Items: arr})
 				return nil
 			}
-			return fmt.Errorf("expected array at end of %q, got %T", path, cur)
+			return &pathError{path: strings.Join(at, "/"), err: fmt.Errorf("expected array at end of %q, got %T", path, cur)}
 		}
 		seg := segs[i]
 		switch node := cur.(type) {
-		case map[string]interface{}:
-			nxt, ok := node[seg]
+		case *Object:
+			nxt, ok := node.Get(seg)
 			if !ok {
 				return nil
 			} // path just doesn't exist; skip
-			return walk(nxt, i+1)
+			return walk(nxt, i+1, append(at, seg))
 		case []interface{}:
 			if seg == "*" {
-				for _, elem := range node {
-					if err := walk(elem, i+1); err != nil {
+				for j, elem := range node {
+					if err := walk(elem, i+1, append(at, strconv.Itoa(j))); err != nil {
 						return err
 					}
 				}
@@ -182,7 +236,7 @@ func findArrays(root map[string]interface{}, path string) ([][]interface{}, erro
 			}
 			if idx, ok := isIndex(seg); ok {
 				if idx >= 0 && idx < len(node) {
-					return walk(node[idx], i+1)
+					return walk(node[idx], i+1, append(at, seg))
 				}
 				return nil
 			}
@@ -191,7 +245,52 @@ func findArrays(root map[string]interface{}, path string) ([][]interface{}, erro
 			return nil // dead path
 		}
 	}
-	if err := walk(root, 0); err != nil {
+	if err := walk(root, 0, make([]string, 0, len(segs))); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// expandPath returns the concrete paths that exist in root and match a
+// wildcard path, where "*" matches every index of an array.
+func expandPath(root *Object, path string) ([]string, error) {
+	segs := split(path)
+	var out []string
+	var walk func(cur interface{}, i int, at []string) error
+	walk = func(cur interface{}, i int, at []string) error {
+		if i == len(segs) {
+			out = append(out, strings.Join(at, "/"))
+			return nil
+		}
+		seg := segs[i]
+		switch node := cur.(type) {
+		case *Object:
+			nxt, ok := node.Get(seg)
+			if !ok {
+				return nil
+			}
+			return walk(nxt, i+1, append(at, seg))
+		case []interface{}:
+			if seg == "*" {
+				for j, elem := range node {
+					if err := walk(elem, i+1, append(at, strconv.Itoa(j))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if idx, ok := isIndex(seg); ok {
+				if idx >= 0 && idx < len(node) {
+					return walk(node[idx], i+1, append(at, seg))
+				}
+				return nil
+			}
+			return fmt.Errorf("invalid array segment %q in %q", seg, path)
+		default:
+			return nil
+		}
+	}
+	if err := walk(root, 0, make([]string, 0, len(segs))); err != nil {
 		return nil, err
 	}
 	return out, nil
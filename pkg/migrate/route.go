@@ -0,0 +1,148 @@
+package migrate
+
+import (
+	"sort"
+	"strings"
+)
+
+// Edge costs used by findChain. Hand-written migrations are preferred over
+// generated reverses, and reverses that skipped steps are a last resort.
+const (
+	costWritten   = 10
+	costGenerated = 15
+	costLossy     = 100
+)
+
+// maxReportedRoutes caps how many tied routes an AmbiguousRouteError lists.
+const maxReportedRoutes = 10
+
+func edgeCost(m Migration) int {
+	switch {
+	case m.Lossy:
+		return costLossy
+	case m.Generated:
+		return costGenerated
+	}
+	return costWritten
+}
+
+// neighbors lists the versions reachable from v in one hop, sorted. Besides
+// exact migrations this includes range migrations whose From matches v.
+func (e *Engine) neighbors(v string) []string {
+	next := append([]string(nil), e.graph[v]...)
+	for _, r := range e.ranges {
+		if ok, _ := matchRange(e.scheme, r.From, v); ok && r.To != v {
+			next = append(next, r.To)
+		}
+	}
+	sort.Strings(next)
+	out := next[:0]
+	for i, n := range next {
+		if i == 0 || n != next[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// edge returns the migration for the hop a->b: an exact migration if there is
+// one, otherwise the first range migration (by From) that matches a.
+func (e *Engine) edge(a, b string) (Migration, bool) {
+	if m, ok := e.migrations[a+"->"+b]; ok && !isRange(a) {
+		return m, true
+	}
+	for _, r := range e.ranges {
+		if r.To != b {
+			continue
+		}
+		if ok, _ := matchRange(e.scheme, r.From, a); ok {
+			return r, true
+		}
+	}
+	return Migration{}, false
+}
+
+// route finds the cheapest chain from->to passing through via in order.
+func (e *Engine) route(from, to string, via []string) ([]string, int, error) {
+	stops := append(append([]string{from}, via...), to)
+	chain := []string{from}
+	total := 0
+	for i := 0; i < len(stops)-1; i++ {
+		if stops[i] == stops[i+1] {
+			continue
+		}
+		seg, cost, err := e.findChain(stops[i], stops[i+1])
+		if err != nil {
+			return nil, 0, err
+		}
+		chain = append(chain, seg[1:]...)
+		total += cost
+	}
+	return chain, total, nil
+}
+
+// findChain runs Dijkstra over the migration graph. Neighbours are visited in
+// sorted order so the result does not depend on load order; if more than one
+// route has the minimal cost, an AmbiguousRouteError lists them.
+func (e *Engine) findChain(from, to string) ([]string, int, error) {
+	dist := map[string]int{from: 0}
+	preds := map[string][]string{}
+	done := map[string]bool{}
+	for {
+		cur, found := "", false
+		for v, d := range dist {
+			if done[v] {
+				continue
+			}
+			if !found || d < dist[cur] || (d == dist[cur] && v < cur) {
+				cur, found = v, true
+			}
+		}
+		if !found || cur == to {
+			break
+		}
+		done[cur] = true
+		for _, nxt := range e.neighbors(cur) {
+			if done[nxt] {
+				continue
+			}
+			m, _ := e.edge(cur, nxt)
+			d := dist[cur] + edgeCost(m)
+			old, seen := dist[nxt]
+			switch {
+			case !seen || d < old:
+				dist[nxt] = d
+				preds[nxt] = []string{cur}
+			case d == old:
+				preds[nxt] = append(preds[nxt], cur)
+			}
+		}
+	}
+	if _, ok := dist[to]; !ok {
+		return nil, 0, &NoPathError{From: from, To: to}
+	}
+
+	var routes [][]string
+	var walk func(v string, tail []string)
+	walk = func(v string, tail []string) {
+		if len(routes) >= maxReportedRoutes {
+			return
+		}
+		tail = append([]string{v}, tail...)
+		if v == from {
+			routes = append(routes, tail)
+			return
+		}
+		for _, p := range preds[v] {
+			walk(p, tail)
+		}
+	}
+	walk(to, nil)
+	if len(routes) > 1 {
+		sort.Slice(routes, func(i, j int) bool {
+			return strings.Join(routes[i], "\x00") < strings.Join(routes[j], "\x00")
+		})
+		return nil, 0, &AmbiguousRouteError{From: from, To: to, Routes: routes}
+	}
+	return routes[0], dist[to], nil
+}
@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestPlanRoutes plans routes over small graphs. Edges are written "a>b", with
+// ":gen" or ":lossy" for generated and lossy reverses.
+func TestPlanRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		edges     string
+		from, to  string
+		via       []string
+		wantChain string
+		wantCost  int
+		wantTies  [][]string // routes of an AmbiguousRouteError
+		wantNo    bool       // NoPathError
+	}{
+		{name: "direct beats two hops", edges: "a>b b>c a>c", from: "a", to: "c", wantChain: "a c", wantCost: costWritten},
+		{name: "generated beats two written", edges: "a>b b>c a>c:gen", from: "a", to: "c", wantChain: "a c", wantCost: costGenerated},
+		{name: "lossy is a last resort", edges: "a>b b>c c>d a>d:lossy", from: "a", to: "d", wantChain: "a b c d", wantCost: 3 * costWritten},
+		{name: "lossy when nothing else", edges: "a>b b>a:lossy", from: "b", to: "a", wantChain: "b a", wantCost: costLossy},
+		{name: "mixed costs add up", edges: "a>b:gen b>c:lossy", from: "a", to: "c", wantChain: "a b c", wantCost: costGenerated + costLossy},
+		{name: "equal-cost tie", edges: "a>c c>d a>b b>d", from: "a", to: "d", wantTies: [][]string{{"a", "b", "d"}, {"a", "c", "d"}}},
+		{name: "tie of generated routes", edges: "a>b:gen b>d:gen a>c:gen c>d:gen", from: "a", to: "d", wantTies: [][]string{{"a", "b", "d"}, {"a", "c", "d"}}},
+		{name: "cheaper route breaks tie", edges: "a>b b>d a>c c>d:gen", from: "a", to: "d", wantChain: "a b d", wantCost: 2 * costWritten},
+		{name: "via settles a tie", edges: "a>b b>d a>c c>d", from: "a", to: "d", via: []string{"c"}, wantChain: "a c d", wantCost: 2 * costWritten},
+		{name: "via takes a dearer route", edges: "a>d a>b b>d", from: "a", to: "d", via: []string{"b"}, wantChain: "a b d", wantCost: 2 * costWritten},
+		{name: "via several stops", edges: "a>b b>c c>d a>d b>d", from: "a", to: "d", via: []string{"b", "c"}, wantChain: "a b c d", wantCost: 3 * costWritten},
+		{name: "via may go back", edges: "a>b b>a", from: "a", to: "a", via: []string{"b"}, wantChain: "a b a", wantCost: 2 * costWritten},
+		{name: "via unreachable", edges: "a>b b>c", from: "a", to: "c", via: []string{"x"}, wantNo: true},
+		{name: "no path", edges: "a>b c>d", from: "a", to: "d", wantNo: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			for _, edge := range strings.Fields(tt.edges) {
+				spec, kind, _ := strings.Cut(edge, ":")
+				from, to, _ := strings.Cut(spec, ">")
+				m := Migration{Name: edge, From: from, To: to, Generated: kind != "", Lossy: kind == "lossy"}
+				if err := e.addMigration(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			plan, err := e.Plan(tt.from, tt.to, Via(tt.via...))
+			var amb *AmbiguousRouteError
+			var nopath *NoPathError
+			switch {
+			case tt.wantTies != nil:
+				if !errors.As(err, &amb) || !reflect.DeepEqual(amb.Routes, tt.wantTies) {
+					t.Fatalf("Plan error = %v, want ambiguous routes %v", err, tt.wantTies)
+				}
+			case tt.wantNo:
+				if !errors.As(err, &nopath) {
+					t.Fatalf("Plan error = %v, want a NoPathError", err)
+				}
+			case err != nil:
+				t.Fatalf("Plan: %v", err)
+			default:
+				if got := strings.Join(plan.Chain, " "); got != tt.wantChain || plan.Cost != tt.wantCost {
+					t.Errorf("Plan = %s (cost %d), want %s (cost %d)", got, plan.Cost, tt.wantChain, tt.wantCost)
+				}
+			}
+		})
+	}
+}
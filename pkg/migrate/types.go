@@ -1,11 +1,35 @@
 package migrate
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Migration describes a version-to-version set of steps.
 type Migration struct {
 	Name  string          `json:"name,omitempty"`
 	From  string          `json:"from"`
 	To    string          `json:"to"`
 	Steps []MigrationStep `json:"steps"`
+
+	// VersionField is the slash path holding the document's version. It is checked
+	// against From before the hop and set to To after it; empty uses the engine's.
+	VersionField string `json:"versionField,omitempty"`
+
+	Source    string `json:"-"` // file the migration was loaded from
+	Generated bool   `json:"-"` // auto-generated reverse of a loaded migration
+	Lossy     bool   `json:"-"` // generated reverse that had to skip forward steps
+
+	Dropped []DroppedStep `json:"-"` // forward steps a lossy reverse does not undo
+}
+
+// DroppedStep is a forward step that a generated reverse could not undo.
+type DroppedStep struct {
+	Index  int // index into the forward migration's Steps
+	Step   MigrationStep
+	Reason string
+	At     int // reverse steps that run before the point where Step would be undone
 }
 
 // MigrationStep is a single operation.
@@ -19,3 +43,25 @@ type MigrationStep struct {
 	Rule       map[string]interface{} `json:"rule,omitempty"`       // for mapArray
 	Reversible *bool                  `json:"reversible,omitempty"` // nil=>auto; false=>do not invert
 }
+
+// String renders the step compactly, e.g. `move from=a/b to=a/c`.
+func (s MigrationStep) String() string {
+	var b strings.Builder
+	b.WriteString(s.Op)
+	for _, kv := range [][2]string{
+		{"from", s.From}, {"to", s.To}, {"path", s.Path},
+		{"wrapAs", s.WrapAs}, {"unwrapTo", s.UnwrapTo},
+	} {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, " %s=%s", kv[0], kv[1])
+		}
+	}
+	if s.Rule != nil {
+		r, _ := json.Marshal(s.Rule)
+		fmt.Fprintf(&b, " rule=%s", r)
+	}
+	if s.Reversible != nil {
+		fmt.Fprintf(&b, " reversible=%t", *s.Reversible)
+	}
+	return b.String()
+}
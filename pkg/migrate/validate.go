@@ -1,10 +1,13 @@
 package migrate
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/santhosh-tekuri/jsonschema/v5"
 )
@@ -18,63 +21,69 @@ func NewValidator() *Validator {
 	return &Validator{schemas: make(map[string]*jsonschema.Schema)}
 }
 
-// LoadAll compiles all *.json schemas in dir.
+// LoadAll compiles all *.json schemas in dir and its subdirectories.
 func (v *Validator) LoadAll(dir string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-	for _, ent := range entries {
-		if ent.IsDir() || filepath.Ext(ent.Name()) != ".json" {
-			continue
-		}
-		// version without extension, e.g. "v1" from "v1.json"
-		version := ent.Name()
-		version = version[:len(version)-len(filepath.Ext(version))]
+	return v.load(os.DirFS(dir), ".", dir)
+}
 
-		path := filepath.Join(dir, ent.Name())
+// LoadFS is LoadAll for schemas under dir in fsys, e.g. an embed.FS.
+func (v *Validator) LoadFS(fsys fs.FS, dir string) error {
+	return v.load(fsys, dir, "")
+}
 
-		// Open file and register it under the resource name equal to the file name (e.g. "v1.json")
-		f, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("failed to open schema %s: %w", path, err)
+func (v *Validator) load(fsys fs.FS, dir, root string) error {
+	return walkJSONFiles(fsys, dir, root, func(path string, b []byte) error {
+		// version without extension, e.g. "v1" from "v1.json"
+		version := filepath.Base(path)
+		version = version[:len(version)-len(filepath.Ext(version))]
+		if _, dup := v.schemas[version]; dup {
+			return &LoadError{File: path, Err: fmt.Errorf("another schema for version %s is already loaded", version)}
 		}
 
 		compiler := jsonschema.NewCompiler()
 
-		resourceName := ent.Name() // use the filename (with .json) as the resource key
-		if err := compiler.AddResource(resourceName, f); err != nil {
-			f.Close()
-			return fmt.Errorf("failed to add schema resource %s: %w", path, err)
+		resourceName := filepath.Base(path) // use the filename (with .json) as the resource key
+		if err := compiler.AddResource(resourceName, bytes.NewReader(b)); err != nil {
+			return &LoadError{File: path, Err: fmt.Errorf("failed to add schema resource: %w", err)}
 		}
 
 		// Compile using the same resource name
 		sch, err := compiler.Compile(resourceName)
-		// Close file after compile (compiler will have read it)
-		_ = f.Close()
 		if err != nil {
-			return fmt.Errorf("compile schema %s: %w", ent.Name(), err)
+			return &LoadError{File: path, Err: fmt.Errorf("compile schema: %w", err)}
 		}
 
 		v.schemas[version] = sch
+		return nil
+	})
+}
+
+// Versions lists the versions that have a schema, sorted.
+func (v *Validator) Versions() []string {
+	out := make([]string, 0, len(v.schemas))
+	for version := range v.schemas {
+		out = append(out, version)
 	}
-	return nil
+	sort.Strings(out)
+	return out
 }
 
-// Validate ensures doc conforms to schema for given version.
-func (v *Validator) Validate(version string, doc map[string]interface{}) error {
+// Validate ensures doc conforms to schema for given version. doc may be an
+// *Object or any value encoding/json can marshal.
+func (v *Validator) Validate(version string, doc interface{}) error {
 	sch, ok := v.schemas[version]
 	if !ok {
 		return fmt.Errorf("no schema for version %s", version)
 	}
-	// re-marshal to ensure canonical interface{} decoding
+	// re-marshal to ensure canonical interface{} decoding; numbers stay json.Number
+	// so large integers are checked against the schema exactly
 	b, _ := json.Marshal(doc)
 	var redecoded interface{}
-	if err := json.Unmarshal(b, &redecoded); err != nil {
+	if err := newDecoder(bytes.NewReader(b)).Decode(&redecoded); err != nil {
 		return err
 	}
 	if err := sch.Validate(redecoded); err != nil {
-		return fmt.Errorf("schema validation failed for version %s: %w", version, err)
+		return &ValidationError{Version: version, Err: err}
 	}
 	return nil
 }
@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionLatest can be passed as the target version to mean the highest known version.
+const VersionLatest = "latest"
+
+// VersionScheme orders version strings.
+type VersionScheme interface {
+	Name() string
+	// Compare returns -1, 0 or +1, or an error if a or b cannot be ordered.
+	Compare(a, b string) (int, error)
+}
+
+// Built-in version schemes.
+var (
+	// Opaque versions are only ever equal or unequal; this is the default.
+	Opaque VersionScheme = opaqueScheme{}
+	// Integer versions are whole numbers with an optional "v" prefix: v1, 2, v10.
+	Integer VersionScheme = integerScheme{}
+	// Semver versions are MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD] with an optional "v" prefix.
+	Semver VersionScheme = semverScheme{}
+)
+
+// SchemeByName returns the built-in scheme called name.
+func SchemeByName(name string) (VersionScheme, error) {
+	for _, s := range []VersionScheme{Opaque, Integer, Semver} {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown version scheme %q", name)
+}
+
+// WithVersionScheme sets how the engine orders versions and evaluates ranges.
+func (e *Engine) WithVersionScheme(s VersionScheme) *Engine {
+	e.scheme = s
+	return e
+}
+
+// LatestVersion returns the highest version any loaded migration starts or ends at.
+func (e *Engine) LatestVersion() (string, error) {
+	var versions []string
+	seen := map[string]bool{}
+	for _, m := range e.migrations {
+		for _, v := range []string{m.From, m.To} {
+			if !isRange(v) && !seen[v] {
+				seen[v] = true
+				versions = append(versions, v)
+			}
+		}
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions loaded")
+	}
+	sort.Strings(versions) // ties between equal versions resolve deterministically
+	best := versions[0]
+	for _, v := range versions[1:] {
+		c, err := e.scheme.Compare(v, best)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve %q with the %s version scheme: %w", VersionLatest, e.scheme.Name(), err)
+		}
+		if c >= 0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+// sameVersion reports whether a and b name the same version under the engine's scheme.
+func (e *Engine) sameVersion(a, b string) bool {
+	if a == b {
+		return true
+	}
+	c, err := e.scheme.Compare(a, b)
+	return err == nil && c == 0
+}
+
+// ---- Ranges ----
+
+// isRange reports whether v is a version range such as ">=1.2 <2.0" rather than a version.
+func isRange(v string) bool {
+	return strings.ContainsAny(v, "<>=")
+}
+
+// matchRange reports whether version satisfies rng, a space-separated list of
+// constraints (=, <, <=, >, >=) that must all hold.
+func matchRange(s VersionScheme, rng, version string) (bool, error) {
+	fields := strings.Fields(rng)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("empty version range")
+	}
+	for i := 0; i < len(fields); i++ {
+		f, op := fields[i], ""
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(f, o) {
+				op = o
+				break
+			}
+		}
+		want := f[len(op):]
+		if want == "" && op != "" && i+1 < len(fields) {
+			i++ // operator written apart from its version: ">= 1.2"
+			want = fields[i]
+		}
+		if want == "" {
+			return false, fmt.Errorf("range %q: constraint %q has no version", rng, f)
+		}
+		c, err := s.Compare(version, want)
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		switch op {
+		case "=", "":
+			ok = c == 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ---- Schemes ----
+
+type opaqueScheme struct{}
+
+func (opaqueScheme) Name() string { return "opaque" }
+
+func (opaqueScheme) Compare(a, b string) (int, error) {
+	if a == b {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("opaque versions %q and %q are unordered", a, b)
+}
+
+type integerScheme struct{}
+
+func (integerScheme) Name() string { return "integer" }
+
+func (integerScheme) Compare(a, b string) (int, error) {
+	x, err := parseInteger(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parseInteger(b)
+	if err != nil {
+		return 0, err
+	}
+	return cmpInt(x, y), nil
+}
+
+func parseInteger(v string) (int, error) {
+	n, err := strconv.Atoi(trimV(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer version %q", v)
+	}
+	return n, nil
+}
+
+type semverScheme struct{}
+
+func (semverScheme) Name() string { return "semver" }
+
+type semver struct {
+	nums [3]int
+	pre  []string
+}
+
+func (semverScheme) Compare(a, b string) (int, error) {
+	x, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range x.nums {
+		if c := cmpInt(x.nums[i], y.nums[i]); c != 0 {
+			return c, nil
+		}
+	}
+	return comparePrerelease(x.pre, y.pre), nil
+}
+
+func parseSemver(v string) (semver, error) {
+	var out semver
+	s := trimV(v)
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i] // build metadata does not affect ordering
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		out.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return out, fmt.Errorf("invalid semver %q", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, fmt.Errorf("invalid semver %q", v)
+		}
+		out.nums[i] = n
+	}
+	return out, nil
+}
+
+// comparePrerelease follows semver precedence: a release sorts after its
+// prereleases, numeric identifiers sort before alphanumeric ones.
+func comparePrerelease(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, xErr := strconv.Atoi(a[i])
+		y, yErr := strconv.Atoi(b[i])
+		switch {
+		case xErr == nil && yErr == nil:
+			if c := cmpInt(x, y); c != 0 {
+				return c
+			}
+		case xErr == nil:
+			return -1
+		case yErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(a), len(b))
+}
+
+func trimV(v string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(v), "v"), "V")
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+// The precedence example from the semver spec, lowest first.
+func TestSemverPrereleaseOrder(t *testing.T) {
+	order := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1-0", "1.0.1"}
+	for i, a := range order {
+		for j, b := range order {
+			c, err := Semver.Compare(a, b)
+			if err != nil {
+				t.Fatalf("Compare(%s, %s): %v", a, b, err)
+			}
+			if want := cmpInt(i, j); c != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", a, b, c, want)
+			}
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		scheme  VersionScheme
+		a, b    string
+		want    int
+		wantErr string
+	}{
+		{Semver, "1.2", "1.2.0", 0, ""},
+		{Semver, "v2", "1.9.9", 1, ""},
+		{Semver, "1.0.0+build.1", "1.0.0+build.2", 0, ""},
+		{Semver, "1.10.0", "1.9.0", 1, ""},
+		{Semver, "1.0.0-rc.1+build", "1.0.0-rc.1", 0, ""},
+		{Semver, "1.2.3.4", "1.0.0", 0, `invalid semver "1.2.3.4"`},
+		{Semver, "1.x", "1.0.0", 0, `invalid semver "1.x"`},
+		{Integer, "v10", "9", 1, ""},
+		{Integer, "3", "V3", 0, ""},
+		{Integer, "1.5", "1", 0, `invalid integer version "1.5"`},
+		{Opaque, "a", "a", 0, ""},
+		{Opaque, "a", "b", 0, "unordered"},
+	}
+	for _, tt := range tests {
+		c, err := tt.scheme.Compare(tt.a, tt.b)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s Compare(%s, %s) error = %v, want %q", tt.scheme.Name(), tt.a, tt.b, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s Compare(%s, %s): %v", tt.scheme.Name(), tt.a, tt.b, err)
+		case c != tt.want:
+			t.Errorf("%s Compare(%s, %s) = %d, want %d", tt.scheme.Name(), tt.a, tt.b, c, tt.want)
+		}
+	}
+}
+
+func TestMatchRange(t *testing.T) {
+	tests := []struct {
+		scheme  VersionScheme
+		rng     string
+		version string
+		want    bool
+		wantErr string
+	}{
+		{Semver, ">=1.2 <2.0", "1.2.0", true, ""},
+		{Semver, ">=1.2 <2.0", "1.9.9", true, ""},
+		{Semver, ">=1.2 <2.0", "2.0.0", false, ""},
+		{Semver, ">=1.2 <2.0", "1.1.9", false, ""},
+		{Semver, ">= 1.2 < 2.0", "1.5.0", true, ""},
+		{Semver, "<2.0", "2.0.0-rc.1", true, ""},
+		{Semver, ">1.0.0", "1.0.0+build", false, ""},
+		{Semver, "=1.2", "1.2.0", true, ""},
+		{Semver, "1.2", "1.2.1", false, ""},
+		{Semver, "<=1.2.3", "v1.2.3", true, ""},
+		{Integer, ">2 <=5", "5", true, ""},
+		{Integer, ">2 <=5", "2", false, ""},
+		{Opaque, "=a", "a", true, ""},
+		{Semver, "", "1.0.0", false, "empty version range"},
+		{Semver, ">=", "1.0.0", false, `constraint ">=" has no version`},
+		{Semver, ">=1.0 <", "1.0.0", false, `constraint "<" has no version`},
+		{Semver, ">=1.x", "1.0.0", false, `invalid semver "1.x"`},
+		{Opaque, ">a", "b", false, "unordered"},
+	}
+	for _, tt := range tests {
+		got, err := matchRange(tt.scheme, tt.rng, tt.version)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("matchRange(%s, %q, %s) error = %v, want %q", tt.scheme.Name(), tt.rng, tt.version, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("matchRange(%s, %q, %s): %v", tt.scheme.Name(), tt.rng, tt.version, err)
+		case got != tt.want:
+			t.Errorf("matchRange(%s, %q, %s) = %v, want %v", tt.scheme.Name(), tt.rng, tt.version, got, tt.want)
+		}
+	}
+}